@@ -3,51 +3,474 @@ package config
 import (
 	"flag"
 	"fmt"
+	"math"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	Source        string
-	Destination   string
-	CreateDir     *rune
-	DeleteDir     *rune
-	CreateFile    *rune
-	OverwriteFile *rune
-	DeleteFile    *rune
+	Source             string
+	Destination        string
+	CreateDir          *rune
+	DeleteDir          *rune
+	CreateFile         *rune
+	OverwriteFile      *rune
+	DeleteFile         *rune
+	IgnoreChanged      bool
+	Placeholder        string
+	LinkDest           []string
+	TimeLimit          time.Duration
+	Flatten            bool
+	OnCollision        string
+	AtomicDir          bool
+	VerifyExisting     bool
+	Repair             bool
+	Umask              int // -1 keeps the process umask
+	SkipDirLinks       bool
+	SummaryJSON        bool
+	RsyncExitCodes     bool
+	AlignMetadata      bool
+	MaxSymlinkDepth    int
+	ProgressLog        string
+	LogMaxSize         int64
+	LogMaxFiles        int
+	NoCrossMountDelete bool
+	PerDirConcurrency  int
+	List               bool
+	ListFormat         string
+	ListStyle          string
+	SubtreeCache       string
+	CopyMethod         string
+	Sparse             bool
+	SparseMinHole      int64
+	Verify             string
+	Filters            []FilterRule
+	WarnHardlinks      bool
+	DeleteParallel     int
+	Top                int
+	SnapshotCmd        string
+	SnapshotMount      string
+	SnapshotCleanupCmd string
+	PlanOut            string
+	ApplyPlan          string
+	MmapCompare        bool
+	MmapMinSize        int64
+	ReserveBytes       int64
+	ReservePercent     float64
+	CopyOrder          string
+	NoDestScan         bool
+	Inplace            bool
+	RampUp             time.Duration
+	VerifyManifest     string
+	CollectScanErrors  bool
+	Mtime              string
+	MtimeFixed         time.Time
+	ScanParallel       int
+	Relative           bool
+	DirectIO           bool
+	BlockSync          bool
+	BlockSyncSize      int64
+	DebugListing       string
+	RateReport         time.Duration
+	FileParallel       int
+	FileParallelMin    int64
+	DryRun             bool
+	UsageReport        bool
+	Checksum           bool
+	Sync               bool
+	FollowSymlinks     bool
+	BufferSize         int
+	MinSize            int64
+	MaxSize            int64
+	Progress           bool
+	NoDelete           bool
+	DeleteExcluded     bool
+	RateLimit          int64
+	ConfigFile         string
+	JSON               bool
+	MtimeTolerance     time.Duration
+	KeepGoing          bool
+	DetectMoves        bool
+	Trash              string
+	Log                string
+	Update             bool
+	Perms              bool
+	DeleteAfter        bool
+	Owner              bool
+	Manifest           string
+	Stable             time.Duration
+	MaxDepth           int
+	IgnoreCase         bool
+	Quiet              bool
+	ProgressInterval   time.Duration
+	IgnoreFile         string
+	Retries            int
+	RetryDelay         time.Duration
+	PercentBasis       string
+	BlockSyncCache     string
+}
+
+var (
+	listColumns = []string{"status", "type", "size", "mtime", "path"}
+	copyMethods = []string{"auto", "read-write", "sendfile", "reflink", "clone"}
+)
+
+// stringList is a flag.Value collecting all occurrences of a repeatable flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
 }
 
 func FromCommandLine() (Config, int) {
 	var cfg Config
 	parallel := 5
 	force := false
+	yes, no := false, false
+	umask := ""
+	logMaxSize := "0"
+	sparseMinHole := "4k"
+	mmapMinSize := "64M"
+	reserve := "0"
+	dump := false
+	mtime := "preserve"
+	blockSyncSize := "128k"
+	fileParallelMin := "1G"
+	buffer := "32k"
+	minSize := "0"
+	maxSize := "0"
+	limit := "0"
 	flag.BoolVar(&force, "force", force, "create/delete in destination without confirmation")
 	flag.IntVar(&parallel, "parallel", parallel, "number of concurrent threads")
+	flag.IntVar(&cfg.ScanParallel, "scan-parallel", 4, "number of dirs compared concurrently, independent of -parallel (1 = one at a time)")
+	flag.StringVar(&cfg.Placeholder, "empty-dir-placeholder", "", "name of a zero-byte file written into destination dirs whose source is empty (e.g. .keep)")
+	flag.Var((*stringList)(&cfg.LinkDest), "link-dest", "hard-link new files identical to the same file in this dir (repeatable, searched in order)")
+	flag.DurationVar(&cfg.TimeLimit, "time-limit", 0, "stop starting new work after this duration, in-flight copies are finished (0 = no limit)")
+	flag.BoolVar(&cfg.Flatten, "flatten", false, "copy all files directly into the destination dir, no dirs are created or deleted")
+	flag.StringVar(&cfg.OnCollision, "on-collision", "error", "what to do when flattened file names collide: skip, rename or error")
+	flag.BoolVar(&cfg.AtomicDir, "atomic-dir", false, "build the new destination in a sibling (destination).tmp dir and swap it in when done")
+	flag.BoolVar(&cfg.VerifyExisting, "verify-existing", false, "audit the destination by hashing both sides and report mismatches, nothing is copied or deleted")
+	flag.BoolVar(&cfg.Repair, "repair", false, "with -verify-existing, copy mismatching files again")
+	flag.StringVar(&umask, "umask", umask, "octal umask for created files and dirs, e.g. 022 (default: process umask)")
+	flag.BoolVar(&cfg.SkipDirLinks, "exclude-symlinks-to-dirs", false, "neither follow nor recreate symlinks pointing to directories")
+	flag.BoolVar(&cfg.SummaryJSON, "summary-json", false, "additionally write the final summary as a JSON line to stderr")
+	flag.BoolVar(&cfg.RsyncExitCodes, "rsync-exit-codes", false, "exit with rsync's exit codes (11 I/O error, 23 partial transfer, 24 files changed, 30 time limit)")
+	flag.BoolVar(&cfg.AlignMetadata, "align-metadata", false, "bring mtime, mode, owner and xattrs of identical destination files in line with the source")
+	flag.IntVar(&cfg.MaxSymlinkDepth, "max-symlink-depth", 0, "max. number of chained symlinks followed when resolving a source entry (0 = no limit)")
+	flag.StringVar(&cfg.ProgressLog, "progress-log", "", "write every progress message to this file")
+	flag.StringVar(&logMaxSize, "log-max-size", logMaxSize, "rotate the progress log and -log when it exceeds this size, e.g. 100MB (0 = never)")
+	flag.IntVar(&cfg.LogMaxFiles, "log-max-files", 5, "number of rotated progress log and -log files to keep")
+	flag.BoolVar(&cfg.NoCrossMountDelete, "no-cross-mount-delete", false, "never delete anything on a different device than the destination dir, e.g. mounted drives")
+	flag.IntVar(&cfg.PerDirConcurrency, "per-dir-concurrency", 0, "max. number of concurrent copies into the same destination dir (0 = no limit)")
+	flag.BoolVar(&cfg.List, "list", false, "list the status of all entries (new, changed, identical, orphan) without changing anything")
+	flag.StringVar(&cfg.ListFormat, "list-format", "status,size,mtime,path", "comma separated columns of -list: status, type, size, mtime, path")
+	flag.StringVar(&cfg.ListStyle, "list-style", "tsv", "output style of -list: tsv (tab separated) or aligned")
+	flag.StringVar(&cfg.SubtreeCache, "subtree-cache", "", "file caching aggregate hashes of source and destination dirs, subtrees unchanged on both sides are skipped on the next run (see README)")
+	flag.StringVar(&cfg.CopyMethod, "copy-method", "auto", "how file content is copied: auto, read-write, sendfile, reflink (Linux) or clone (macOS)")
+	flag.BoolVar(&cfg.Sparse, "sparse", false, "keep holes and turn runs of zeros into holes in the destination, with every copy method")
+	flag.StringVar(&sparseMinHole, "sparse-min-hole", sparseMinHole, "min. length of a zero run to become a hole, rounded up to the destination block size")
+	flag.StringVar(&cfg.Verify, "verify", "none", "check copied files: none, light (size and mtime) or full (content hash)")
+	flag.Var(filterFlag{&cfg.Filters, "include"}, "include", "include entries matching the pattern (repeatable, see -filter)")
+	flag.Var(filterFlag{&cfg.Filters, "exclude"}, "exclude", "exclude entries matching the pattern (repeatable, see -filter)")
+	flag.Var(filterFlag{&cfg.Filters, "include-from"}, "include-from", "read include patterns from file, one per line")
+	flag.Var(filterFlag{&cfg.Filters, "exclude-from"}, "exclude-from", "read exclude patterns from file, one per line")
+	flag.Var(filterFlag{&cfg.Filters, "filter"}, "filter", "'+ pattern' to include or '- pattern' to exclude. All filter flags form one rule list in command line order, the first match wins")
+	flag.BoolVar(&cfg.WarnHardlinks, "warn-hardlinks", false, "report source files sharing an inode, which are copied as separate files")
+	flag.IntVar(&cfg.DeleteParallel, "delete-parallel", 4, "number of concurrent deletions, independent of -parallel")
+	flag.IntVar(&cfg.Top, "top", 0, "list the N largest copied files in the summary")
+	flag.StringVar(&cfg.SnapshotCmd, "snapshot-cmd", "", "shell command creating a snapshot of the source before scanning")
+	flag.StringVar(&cfg.SnapshotMount, "snapshot-mount", "", "dir where the snapshot is mounted, used as source for the run")
+	flag.StringVar(&cfg.SnapshotCleanupCmd, "snapshot-cleanup-cmd", "", "shell command removing the snapshot after the run")
+	flag.StringVar(&cfg.PlanOut, "plan", "", "write the actions of the run to this file instead of executing them")
+	flag.StringVar(&cfg.ApplyPlan, "apply-plan", "", "execute the actions of a (reviewed) plan file without scanning, skipping those whose files changed")
+	flag.BoolVar(&cfg.MmapCompare, "mmap-compare", false, "compare file contents byte by byte, stopping at the first difference, instead of hashing both")
+	flag.StringVar(&mmapMinSize, "mmap-min-size", mmapMinSize, "with -mmap-compare, memory-map files of at least this size instead of reading them")
+	flag.StringVar(&reserve, "reserve", reserve, "stop copying before the free space on the destination drops below this size (e.g. 10G) or percentage (e.g. 5%), Linux and macOS only")
+	flag.StringVar(&cfg.CopyOrder, "copy-order", "any", "order of copies within a dir: any, or locality (by inode number, reduces seeking on spinning disks)")
+	flag.BoolVar(&cfg.NoDestScan, "no-dest-scan", false, "look up the source entries in the destination instead of listing destination dirs; destination orphans are not deleted")
+	flag.BoolVar(&cfg.Inplace, "inplace", false, "overwrite changed files in place, keeping hard links to them; an interrupted copy leaves a mix of old and new content")
+	flag.DurationVar(&cfg.RampUp, "ramp-up", 0, "grow the number of concurrent threads from 1 to -parallel over this duration")
+	flag.StringVar(&cfg.VerifyManifest, "verify-manifest", "", "check the files of a single dir against this manifest written by -manifest, or sha256sum style checksum file")
+	flag.BoolVar(&cfg.CollectScanErrors, "collect-scan-errors", false, "skip dirs which cannot be read and report them at the end instead of aborting")
+	flag.StringVar(&mtime, "mtime", mtime, "modification time of copied files: preserve, now, zero (Unix epoch) or fixed:<RFC 3339 time>; unless preserve, files are compared by size only")
+	flag.BoolVar(&cfg.Relative, "relative", false, "recreate the source path below the destination dir, e.g. /var/log/app is mirrored to (destination dir)/var/log/app")
+	flag.BoolVar(&cfg.DirectIO, "direct-io", false, "copy files of 1M and more with O_DIRECT, bypassing the page cache (Linux only, others copy normally)")
+	flag.BoolVar(&cfg.BlockSync, "block-sync", false, "update changed files in place, writing only the blocks which differ (implies -inplace)")
+	flag.StringVar(&blockSyncSize, "block-sync-size", blockSyncSize, "block size compared by -block-sync")
+	flag.StringVar(&cfg.DebugListing, "debug-listing", "", "print what was read from source and destination for this dir (relative to the source dir, or all) and the decision per entry")
+	flag.DurationVar(&cfg.RateReport, "rate-report", 0, "report the throughput of files copied in the last interval and the bytes copied so far at this interval (0 = never)")
+	flag.IntVar(&cfg.FileParallel, "file-parallel", 1, "copy large files as this many ranges concurrently")
+	flag.StringVar(&fileParallelMin, "file-parallel-min", fileParallelMin, "minimum size of files copied in ranges with -file-parallel")
+	flag.BoolVar(&cfg.DryRun, "dry-run", false, "report what would be created, copied and deleted without changing the destination")
+	flag.BoolVar(&cfg.UsageReport, "usage-report", false, "report the used and free space of the destination file system before and after the run, Linux and macOS only")
+	flag.BoolVar(&cfg.Checksum, "checksum", false, "compare files of equal size by content instead of modification time")
+	flag.BoolVar(&cfg.Sync, "fsync", false, "flush every copied file and its dir to disk before continuing, which is durable but slows down copying many files considerably")
+	flag.BoolVar(&cfg.FollowSymlinks, "follow-symlinks", false, "copy the targets of symlinks instead of recreating the links")
+	flag.StringVar(&buffer, "buffer", buffer, "size of the buffer used when file data passes through user space")
+	flag.StringVar(&minSize, "min-size", minSize, "skip source files smaller than this size, e.g. 1k; such destination files are not deleted")
+	flag.StringVar(&maxSize, "max-size", maxSize, "skip source files larger than this size, e.g. 2G; such destination files are not deleted (0 = no limit)")
+	flag.BoolVar(&cfg.Progress, "progress", false, "count the source files and bytes before mirroring to show the percentage done")
+	flag.BoolVar(&cfg.NoDelete, "no-delete", false, "never delete anything in the destination, even with -force")
+	flag.BoolVar(&cfg.DeleteExcluded, "delete-excluded", false, "also delete destination entries excluded by the filter rules")
+	flag.StringVar(&limit, "limit", limit, "max. bytes per second copied by all copies together, e.g. 10M (0 = no limit)")
+	flag.StringVar(&cfg.ConfigFile, "config", "", "run the jobs of this JSON file one after the other instead of the dirs given as arguments, see -config-dump for the fields of a job")
+	flag.BoolVar(&cfg.JSON, "json", false, "write one JSON object per action and a final summary to stdout instead of messages, implies -force")
+	flag.BoolVar(&yes, "yes", false, "answer every confirmation with yes, the same as -force")
+	flag.BoolVar(&no, "no", false, "answer every confirmation with no, so nothing is created, overwritten or deleted")
+	flag.DurationVar(&cfg.MtimeTolerance, "mtime-tolerance", time.Second, "max. difference of modification times still considered equal, e.g. 2s for FAT destinations")
+	flag.BoolVar(&cfg.KeepGoing, "keep-going", false, "skip files and dirs which fail and report them at the end instead of aborting (implies -collect-scan-errors)")
+	flag.BoolVar(&cfg.DetectMoves, "detect-moves", false, "rename destination files to be deleted which have the size and content of a new file instead of copying it; deletes wait until all dirs are compared")
+	flag.StringVar(&cfg.Trash, "trash", "", "move deleted files and dirs to a timestamped dir per run in this dir instead of removing them")
+	flag.StringVar(&cfg.Log, "log", "", "record every action, warning and error with a timestamp in this file, independent of the progress on screen")
+	flag.BoolVar(&cfg.Update, "update", false, "only overwrite destination files which are older than the source, never newer ones")
+	flag.BoolVar(&cfg.Perms, "perms", false, "set the permissions of existing destination dirs and files to those of the source")
+	flag.BoolVar(&cfg.DeleteAfter, "delete-after", false, "delete after all copies are done instead of as soon as possible, skipped if the run does not complete")
+	flag.BoolVar(&cfg.Owner, "owner", false, "give new files and dirs the owner and group of the source (Unix, needs root)")
+	flag.StringVar(&cfg.Manifest, "manifest", "", "write the path, sha256 and size of every destination file copied or found identical to this file, for -verify-manifest; subtrees skipped by -subtree-cache are not listed")
+	flag.DurationVar(&cfg.Stable, "stable", 0, "skip source files whose size or mtime change within this interval, e.g. 2s; only files modified that recently are waited for (0 = off)")
+	flag.IntVar(&cfg.MaxDepth, "max-depth", -1, "mirror the contents of dirs down to this depth below the source, 0 = only its own files; deeper dirs are created empty (-1 = unlimited)")
+	flag.BoolVar(&cfg.IgnoreCase, "ignore-case", false, "rename destination entries whose names differ only in case to the source name instead of replacing them; source names differing only in case are skipped")
+	flag.BoolVar(&cfg.Quiet, "quiet", false, "show no progress, only errors and the summary; with -json not even the summary")
+	flag.DurationVar(&cfg.ProgressInterval, "progress-interval", time.Second, "show max. 1 progress message per interval")
+	flag.StringVar(&cfg.IgnoreFile, "ignore-file", ".mirrorignore", "name of files in source dirs listing patterns to exclude from their subtree, like -exclude; !pattern includes (empty = none)")
+	flag.IntVar(&cfg.Retries, "retries", 0, "retry copies failing with transient errors like timeouts or connection resets this many times")
+	flag.DurationVar(&cfg.RetryDelay, "retry-delay", time.Second, "wait before the first retry of -retries, doubled for each further one")
+	flag.StringVar(&cfg.PercentBasis, "percent-basis", "bytes", "what the percentage done of -progress counts: bytes or files")
+	flag.StringVar(&cfg.BlockSyncCache, "block-sync-cache", "", "dir keeping the block hashes of files updated by -block-sync, so unchanged blocks are not read from the destination on the next run")
+	flag.BoolVar(&dump, "config-dump", false, "print the resolved configuration as JSON and exit")
+	flag.BoolVar(&cfg.IgnoreChanged, "ignore-changed", false, "warn instead of failing when a source file changes during transfer, keeping the copy until the next run copies it again")
 	flag.Parse()
-	if n := flag.NArg(); n != 2 {
+	args := 2
+	if cfg.VerifyManifest != "" {
+		args = 1
+	}
+	if cfg.ConfigFile != "" {
+		args = 0
+	}
+	if n := flag.NArg(); n != args {
+		usage()
+		fmt.Printf("Expected %d arguments, got %d, %v\n", args, n, flag.Args())
+		os.Exit(1)
+	}
+	var err error
+	if cfg.LogMaxSize, err = ParseSize(logMaxSize); err != nil {
+		usage()
+		fmt.Printf("Invalid -log-max-size value: %s\n", err)
+		os.Exit(1)
+	}
+	if cfg.SparseMinHole, err = ParseSize(sparseMinHole); err != nil {
+		usage()
+		fmt.Printf("Invalid -sparse-min-hole value: %s\n", err)
+		os.Exit(1)
+	}
+	if cfg.MmapMinSize, err = ParseSize(mmapMinSize); err != nil {
+		usage()
+		fmt.Printf("Invalid -mmap-min-size value: %s\n", err)
+		os.Exit(1)
+	}
+	if cfg.FileParallelMin, err = ParseSize(fileParallelMin); err != nil {
+		usage()
+		fmt.Printf("Invalid -file-parallel-min value: %s\n", err)
+		os.Exit(1)
+	}
+	if cfg.BlockSyncSize, err = ParseSize(blockSyncSize); err != nil {
+		usage()
+		fmt.Printf("Invalid -block-sync-size value: %s\n", err)
+		os.Exit(1)
+	}
+	if cfg.MinSize, err = ParseSize(minSize); err != nil {
+		usage()
+		fmt.Printf("Invalid -min-size value: %s\n", err)
+		os.Exit(1)
+	}
+	if cfg.MaxSize, err = ParseSize(maxSize); err != nil {
+		usage()
+		fmt.Printf("Invalid -max-size value: %s\n", err)
+		os.Exit(1)
+	}
+	if cfg.RateLimit, err = ParseSize(limit); err != nil {
+		usage()
+		fmt.Printf("Invalid -limit value: %s\n", err)
+		os.Exit(1)
+	}
+	bufferSize, err := ParseSize(buffer)
+	if err != nil || bufferSize > math.MaxInt32 {
+		usage()
+		fmt.Printf("Invalid -buffer value '%s'\n", buffer)
+		os.Exit(1)
+	}
+	cfg.BufferSize = int(bufferSize)
+	if pct, ok := strings.CutSuffix(reserve, "%"); ok {
+		cfg.ReservePercent, err = strconv.ParseFloat(pct, 64)
+	} else {
+		cfg.ReserveBytes, err = ParseSize(reserve)
+	}
+	if err != nil {
 		usage()
-		fmt.Printf("Expected 2 arguments, got %d, %v\n", n, flag.Args())
+		fmt.Printf("Invalid -reserve value '%s': %s\n", reserve, err)
+		os.Exit(1)
+	}
+	switch {
+	case mtime == "preserve" || mtime == "now":
+		cfg.Mtime = mtime
+	case mtime == "zero":
+		cfg.Mtime, cfg.MtimeFixed = mtime, time.Unix(0, 0)
+	case strings.HasPrefix(mtime, "fixed:"):
+		cfg.Mtime = "fixed"
+		if cfg.MtimeFixed, err = time.Parse(time.RFC3339, strings.TrimPrefix(mtime, "fixed:")); err != nil {
+			usage()
+			fmt.Printf("Invalid -mtime value '%s': %s\n", mtime, err)
+			os.Exit(1)
+		}
+	default:
+		usage()
+		fmt.Printf("Invalid -mtime value '%s'\n", mtime)
+		os.Exit(1)
+	}
+	cfg.Umask = -1
+	if umask != "" {
+		u, err := strconv.ParseUint(umask, 8, 32)
+		if err != nil {
+			usage()
+			fmt.Printf("Invalid -umask value '%s'\n", umask)
+			os.Exit(1)
+		}
+		cfg.Umask = int(u)
+	}
+	if err := cfg.validate(); err != nil {
+		usage()
+		fmt.Println(err)
 		os.Exit(1)
 	}
 	cfg.Source = flag.Arg(0)
 	cfg.Destination = flag.Arg(1)
+	if no && (force || yes) {
+		usage()
+		fmt.Println("-no cannot be combined with -force or -yes")
+		os.Exit(1)
+	}
 	cd, dd, cf, of, df := '-', '-', '-', '-', '-'
-	if force {
+	// JSON output cannot prompt
+	if force || yes || cfg.JSON {
 		cd, dd, cf, of, df = 'a', 'a', 'a', 'a', 'a'
 	}
+	if no {
+		cd, dd, cf, of, df = 'x', 'x', 'x', 'x', 'x'
+	}
+	if cfg.NoDelete {
+		dd, df = 'x', 'x'
+	}
 	cfg.CreateDir = &cd
 	cfg.DeleteDir = &dd
 	cfg.CreateFile = &cf
 	cfg.OverwriteFile = &of
 	cfg.DeleteFile = &df
-	if !isDir(cfg.Source) || !isDir(cfg.Destination) {
+	switch {
+	case cfg.ConfigFile != "":
+		// the jobs name the dirs
+	case cfg.VerifyManifest != "":
+		if !isDir(cfg.Source) {
+			fmt.Println("(dir) must be an existing directory")
+			os.Exit(1)
+		}
+	case !isDir(cfg.Source) || !isDir(cfg.Destination):
 		fmt.Println("Both (source dir) and (destination dir) must be existing directories")
 		os.Exit(1)
 	}
+	if dump {
+		if err := cfg.Dump(os.Stdout, parallel); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 	return cfg, parallel
 }
 
+// validate checks the values of c which are not checked when parsed, for the command line and the jobs of -config
+func (c Config) validate() error {
+	if c.OnCollision != "skip" && c.OnCollision != "rename" && c.OnCollision != "error" {
+		return fmt.Errorf("invalid -on-collision value '%s'", c.OnCollision)
+	}
+	for _, c := range strings.Split(c.ListFormat, ",") {
+		if !contains(listColumns, c) {
+			return fmt.Errorf("invalid -list-format column '%s'", c)
+		}
+	}
+	if c.ListStyle != "tsv" && c.ListStyle != "aligned" {
+		return fmt.Errorf("invalid -list-style value '%s'", c.ListStyle)
+	}
+	if !contains(copyMethods, c.CopyMethod) {
+		return fmt.Errorf("invalid -copy-method value '%s'", c.CopyMethod)
+	}
+	if c.Verify != "none" && c.Verify != "light" && c.Verify != "full" {
+		return fmt.Errorf("invalid -verify value '%s'", c.Verify)
+	}
+	if c.CopyOrder != "any" && c.CopyOrder != "locality" {
+		return fmt.Errorf("invalid -copy-order value '%s'", c.CopyOrder)
+	}
+	if c.PercentBasis != "bytes" && c.PercentBasis != "files" {
+		return fmt.Errorf("invalid -percent-basis value '%s'", c.PercentBasis)
+	}
+	if c.Mtime != "preserve" && c.Mtime != "now" && c.Mtime != "zero" && c.Mtime != "fixed" {
+		return fmt.Errorf("invalid -mtime value '%s'", c.Mtime)
+	}
+	for _, s := range []struct {
+		name string
+		v    int64
+	}{
+		{"-log-max-size", c.LogMaxSize}, {"-sparse-min-hole", c.SparseMinHole}, {"-mmap-min-size", c.MmapMinSize},
+		{"-file-parallel-min", c.FileParallelMin}, {"-min-size", c.MinSize}, {"-max-size", c.MaxSize},
+		{"-limit", c.RateLimit}, {"-reserve", c.ReserveBytes},
+	} {
+		if s.v < 0 {
+			return fmt.Errorf("invalid %s value %d", s.name, s.v)
+		}
+	}
+	if c.BlockSyncSize < 1 {
+		return fmt.Errorf("invalid -block-sync-size value %d", c.BlockSyncSize)
+	}
+	if c.BlockSyncCache != "" && !c.BlockSync {
+		return fmt.Errorf("-block-sync-cache needs -block-sync")
+	}
+	if c.MaxSize > 0 && c.MaxSize < c.MinSize {
+		return fmt.Errorf("invalid -max-size value %d, less than -min-size", c.MaxSize)
+	}
+	if c.BufferSize < 1 {
+		return fmt.Errorf("invalid -buffer value %d", c.BufferSize)
+	}
+	if c.ReservePercent < 0 || c.ReservePercent > 100 {
+		return fmt.Errorf("invalid -reserve value %g%%, percentage out of range", c.ReservePercent)
+	}
+	if !diskSpaceSupported && (c.ReserveBytes > 0 || c.ReservePercent > 0) {
+		return fmt.Errorf("-reserve is not supported on this platform")
+	}
+	if !diskSpaceSupported && c.UsageReport {
+		return fmt.Errorf("-usage-report is not supported on this platform")
+	}
+	if c.Umask < -1 || c.Umask > 0777 {
+		return fmt.Errorf("invalid -umask value %o", c.Umask)
+	}
+	if c.Retries < 0 || c.RetryDelay < 0 {
+		return fmt.Errorf("-retries and -retry-delay must not be negative")
+	}
+	if c.ProgressInterval < 0 {
+		return fmt.Errorf("invalid -progress-interval value %s", c.ProgressInterval)
+	}
+	return nil
+}
+
 func usage() {
 	fmt.Println("Usage: mirror (source dir) (destination dir)")
+	fmt.Println("       mirror -verify-manifest (checksum file) (dir)")
+	fmt.Println("       mirror -config (jobs file)")
 	flag.PrintDefaults()
 }
 
@@ -59,3 +482,38 @@ func isDir(path string) bool {
 	}
 	return inf.IsDir()
 }
+
+// ParseSize parses a byte count with an optional unit suffix k, M, G or T (powers of 1024), e.g. 100MB or 2G
+func ParseSize(s string) (int64, error) {
+	v := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSpace(s), "B"), "i")
+	mult := int64(1)
+	if n := len(v); n > 0 {
+		switch v[n-1] {
+		case 'k', 'K':
+			mult = 1 << 10
+		case 'm', 'M':
+			mult = 1 << 20
+		case 'g', 'G':
+			mult = 1 << 30
+		case 't', 'T':
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			v = v[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/mult {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return n * mult, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
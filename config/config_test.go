@@ -0,0 +1,156 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConfig returns the configuration of FromCommandLine without flags
+func testConfig() Config {
+	return Config{
+		OnCollision:      "error",
+		Umask:            -1,
+		LogMaxFiles:      5,
+		ListFormat:       "status,size,mtime,path",
+		ListStyle:        "tsv",
+		CopyMethod:       "auto",
+		SparseMinHole:    4 << 10,
+		Verify:           "none",
+		CopyOrder:        "any",
+		MmapMinSize:      64 << 20,
+		Mtime:            "preserve",
+		BlockSyncSize:    128 << 10,
+		FileParallelMin:  1 << 30,
+		BufferSize:       32 << 10,
+		MtimeTolerance:   time.Second,
+		ProgressInterval: time.Second,
+		RetryDelay:       time.Second,
+		IgnoreFile:       ".mirrorignore",
+		PercentBasis:     "bytes",
+	}
+}
+
+// unsupported returns the error of validate for a flag needing the free disk space, none where it is supported
+func unsupported(flag string) string {
+	if diskSpaceSupported {
+		return ""
+	}
+	return flag + " is not supported"
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr string
+	}{
+		{name: "defaults"},
+		{name: "on-collision", change: func(c *Config) { c.OnCollision = "overwrite" }, wantErr: "-on-collision"},
+		{name: "list-format", change: func(c *Config) { c.ListFormat = "path,owner" }, wantErr: "column 'owner'"},
+		{name: "list-style", change: func(c *Config) { c.ListStyle = "csv" }, wantErr: "-list-style"},
+		{name: "copy-method", change: func(c *Config) { c.CopyMethod = "rsync" }, wantErr: "-copy-method"},
+		{name: "verify", change: func(c *Config) { c.Verify = "strict" }, wantErr: "-verify"},
+		{name: "copy-order", change: func(c *Config) { c.CopyOrder = "size" }, wantErr: "-copy-order"},
+		{name: "percent-basis", change: func(c *Config) { c.PercentBasis = "dirs" }, wantErr: "-percent-basis"},
+		{name: "percent-basis files", change: func(c *Config) { c.PercentBasis = "files" }},
+		{name: "mtime", change: func(c *Config) { c.Mtime = "keep" }, wantErr: "-mtime"},
+		{name: "negative size", change: func(c *Config) { c.MinSize = -1 }, wantErr: "-min-size"},
+		{name: "max below min", change: func(c *Config) { c.MinSize, c.MaxSize = 10, 5 }, wantErr: "-max-size"},
+		{name: "max equals min", change: func(c *Config) { c.MinSize, c.MaxSize = 10, 10 }},
+		{name: "block-sync-size", change: func(c *Config) { c.BlockSyncSize = 0 }, wantErr: "-block-sync-size"},
+		{name: "block-sync-cache", change: func(c *Config) { c.BlockSyncCache = "/tmp" }, wantErr: "needs -block-sync"},
+		{name: "block-sync-cache with block-sync", change: func(c *Config) { c.BlockSyncCache, c.BlockSync = "/tmp", true }},
+		{name: "buffer", change: func(c *Config) { c.BufferSize = 0 }, wantErr: "-buffer"},
+		{name: "reserve percent", change: func(c *Config) { c.ReservePercent = 101 }, wantErr: "-reserve"},
+		{name: "reserve bytes", change: func(c *Config) { c.ReserveBytes = 1 << 30 }, wantErr: unsupported("-reserve")},
+		{name: "reserve percent supported", change: func(c *Config) { c.ReservePercent = 5 }, wantErr: unsupported("-reserve")},
+		{name: "usage-report", change: func(c *Config) { c.UsageReport = true }, wantErr: unsupported("-usage-report")},
+		{name: "umask", change: func(c *Config) { c.Umask = 01000 }, wantErr: "-umask"},
+		{name: "retries", change: func(c *Config) { c.Retries = -1 }, wantErr: "-retries"},
+		{name: "progress-interval", change: func(c *Config) { c.ProgressInterval = -time.Second }, wantErr: "-progress-interval"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			if tt.change != nil {
+				tt.change(&c)
+			}
+			err := c.validate()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// fromArgs returns the configuration of FromCommandLine for the arguments
+func fromArgs(t *testing.T, args ...string) (Config, int) {
+	t.Helper()
+	defer func(args []string, fs *flag.FlagSet) { os.Args, flag.CommandLine = args, fs }(os.Args, flag.CommandLine)
+	os.Args = append([]string{"mirror"}, args...)
+	flag.CommandLine = flag.NewFlagSet("mirror", flag.ContinueOnError)
+	return FromCommandLine()
+}
+
+func TestNoDelete(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	tests := []struct {
+		name               string
+		args               []string
+		create, deleteFile rune
+	}{
+		{name: "prompt", create: '-', deleteFile: '-'},
+		{name: "force", args: []string{"-force"}, create: 'a', deleteFile: 'a'},
+		{name: "no-delete", args: []string{"-no-delete"}, create: '-', deleteFile: 'x'},
+		{name: "no-delete under force", args: []string{"-force", "-no-delete"}, create: 'a', deleteFile: 'x'},
+		{name: "yes", args: []string{"-yes"}, create: 'a', deleteFile: 'a'},
+		{name: "no", args: []string{"-no"}, create: 'x', deleteFile: 'x'},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _ := fromArgs(t, append(tt.args, src, dst)...)
+			if *cfg.CreateFile != tt.create || *cfg.CreateDir != tt.create || *cfg.OverwriteFile != tt.create {
+				t.Errorf("create and overwrite = %c, want %c", *cfg.CreateFile, tt.create)
+			}
+			if *cfg.DeleteFile != tt.deleteFile || *cfg.DeleteDir != tt.deleteFile {
+				t.Errorf("delete file %c, dir %c, want %c", *cfg.DeleteFile, *cfg.DeleteDir, tt.deleteFile)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int64
+		wantErr bool
+	}{
+		{s: "0"},
+		{s: "1024", want: 1024},
+		{s: "1k", want: 1 << 10},
+		{s: "1K", want: 1 << 10},
+		{s: "4KiB", want: 4 << 10},
+		{s: "64M", want: 64 << 20},
+		{s: "2G", want: 2 << 30},
+		{s: "2GB", want: 2 << 30},
+		{s: "1T", want: 1 << 40},
+		{s: " 3m ", want: 3 << 20},
+		{s: "", wantErr: true},
+		{s: "k", wantErr: true},
+		{s: "-1k", wantErr: true},
+		{s: "1.5G", wantErr: true},
+		{s: "1X", wantErr: true},
+		{s: "9999999999T", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseSize(tt.s)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseSize(%q) = %d, %v, want %d, error %v", tt.s, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+//go:build linux || darwin
+
+package config
+
+// diskSpaceSupported is whether the free space of the destination can be read, for -reserve and -usage-report
+const diskSpaceSupported = true
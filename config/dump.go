@@ -0,0 +1,36 @@
+package config
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Dump writes the configuration and parallelism as indented JSON to w.
+// Confirmation settings are written as prompt, all or none.
+func (c Config) Dump(w io.Writer, parallel int) error {
+	d := struct {
+		Config
+		Parallel      int
+		CreateDir     string
+		DeleteDir     string
+		CreateFile    string
+		OverwriteFile string
+		DeleteFile    string
+	}{c, parallel, confirmation(c.CreateDir), confirmation(c.DeleteDir),
+		confirmation(c.CreateFile), confirmation(c.OverwriteFile), confirmation(c.DeleteFile)}
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(d)
+}
+
+func confirmation(r *rune) string {
+	switch {
+	case r == nil || *r == '-':
+		return "prompt"
+	case *r == 'a':
+		return "all"
+	case *r == 'x':
+		return "none"
+	}
+	return string(*r)
+}
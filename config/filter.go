@@ -0,0 +1,74 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FilterRule includes or excludes the entries matching Pattern.
+// Rules are evaluated in command line order, the first matching rule wins and unmatched entries are included.
+type FilterRule struct {
+	Include bool
+	Pattern string
+}
+
+// filterFlag is a flag.Value appending rules of one kind to the shared, ordered rule list
+type filterFlag struct {
+	rules *[]FilterRule
+	kind  string // include, exclude, include-from, exclude-from or filter
+}
+
+func (f filterFlag) String() string {
+	return ""
+}
+
+func (f filterFlag) Set(v string) error {
+	switch f.kind {
+	case "include", "exclude":
+		*f.rules = append(*f.rules, FilterRule{Include: f.kind == "include", Pattern: v})
+	case "include-from", "exclude-from":
+		patterns, err := readPatterns(v)
+		if err != nil {
+			return err
+		}
+		for _, p := range patterns {
+			*f.rules = append(*f.rules, FilterRule{Include: f.kind == "include-from", Pattern: p})
+		}
+	case "filter":
+		rule, err := parseFilter(v)
+		if err != nil {
+			return err
+		}
+		*f.rules = append(*f.rules, rule)
+	}
+	return nil
+}
+
+// parseFilter parses a rule of the form "+ pattern" or "- pattern"
+func parseFilter(v string) (FilterRule, error) {
+	if len(v) < 3 || (v[0] != '+' && v[0] != '-') || v[1] != ' ' {
+		return FilterRule{}, fmt.Errorf("invalid filter rule '%s', expected '+ pattern' or '- pattern'", v)
+	}
+	return FilterRule{Include: v[0] == '+', Pattern: v[2:]}, nil
+}
+
+// readPatterns reads one pattern per line, skipping blank lines and comments starting with # or ;
+func readPatterns(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var patterns []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		if strings.TrimSpace(line) == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, s.Err()
+}
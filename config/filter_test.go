@@ -0,0 +1,59 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilterFlagOrder(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "patterns")
+	if err := os.WriteFile(from, []byte("# comment\n*.tmp\n\n; comment\nkeep/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		args    []string
+		want    []FilterRule
+		wantErr bool
+	}{
+		{
+			name: "command line order",
+			args: []string{"-exclude", "*.log", "-include", "important.log", "-filter", "+ a", "-filter", "- b"},
+			want: []FilterRule{{false, "*.log"}, {true, "important.log"}, {true, "a"}, {false, "b"}},
+		},
+		{
+			name: "include first",
+			args: []string{"-include", "important.log", "-exclude", "*.log"},
+			want: []FilterRule{{true, "important.log"}, {false, "*.log"}},
+		},
+		{
+			name: "files in place of the flag",
+			args: []string{"-include", "a", "-exclude-from", from, "-include-from", from, "-exclude", "b"},
+			want: []FilterRule{{true, "a"}, {false, "*.tmp"}, {false, "keep/"}, {true, "*.tmp"}, {true, "keep/"}, {false, "b"}},
+		},
+		{name: "invalid filter", args: []string{"-filter", "x a"}, wantErr: true},
+		{name: "missing file", args: []string{"-include-from", filepath.Join(dir, "missing")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rules []FilterRule
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			for _, kind := range []string{"include", "exclude", "include-from", "exclude-from", "filter"} {
+				fs.Var(filterFlag{&rules, kind}, kind, "")
+			}
+			err := fs.Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && fmt.Sprint(rules) != fmt.Sprint(tt.want) {
+				t.Errorf("rules = %v, want %v", rules, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Job is one mirror run of a -config file
+type Job struct {
+	Config   Config
+	Parallel int
+}
+
+// jobFields is the JSON form of a job, the same as written by -config-dump
+type jobFields struct {
+	Config
+	Parallel      int
+	CreateDir     string
+	DeleteDir     string
+	CreateFile    string
+	OverwriteFile string
+	DeleteFile    string
+}
+
+// LoadJobs reads a JSON array of jobs from path. Each job is an object with the fields written by -config-dump,
+// fields missing in a job keep the value of base and parallel from the command line.
+func LoadJobs(path string, base Config, parallel int) ([]Job, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config '%s': %w", path, err)
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("parse config '%s': %w", path, err)
+	}
+	jobs := make([]Job, 0, len(raw))
+	for i, r := range raw {
+		f := jobFields{base, parallel, confirmation(base.CreateDir), confirmation(base.DeleteDir),
+			confirmation(base.CreateFile), confirmation(base.OverwriteFile), confirmation(base.DeleteFile)}
+		d := json.NewDecoder(bytes.NewReader(r))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&f); err != nil {
+			return nil, fmt.Errorf("parse job %d of config '%s': %w", i+1, path, err)
+		}
+		cfg := f.Config
+		for _, c := range []struct {
+			dst **rune
+			v   string
+		}{
+			{&cfg.CreateDir, f.CreateDir}, {&cfg.DeleteDir, f.DeleteDir}, {&cfg.CreateFile, f.CreateFile},
+			{&cfg.OverwriteFile, f.OverwriteFile}, {&cfg.DeleteFile, f.DeleteFile},
+		} {
+			r, err := parseConfirmation(c.v)
+			if err != nil {
+				return nil, fmt.Errorf("job %d of config '%s': %w", i+1, path, err)
+			}
+			*c.dst = &r
+		}
+		if cfg.NoDelete {
+			*cfg.DeleteDir, *cfg.DeleteFile = 'x', 'x'
+		}
+		if cfg.Source == "" || cfg.Destination == "" {
+			return nil, fmt.Errorf("job %d of config '%s': Source and Destination are required", i+1, path)
+		}
+		for _, dir := range []string{cfg.Source, cfg.Destination} {
+			if inf, err := os.Stat(dir); err != nil || !inf.IsDir() {
+				return nil, fmt.Errorf("job %d of config '%s': '%s' is not an existing directory", i+1, path, dir)
+			}
+		}
+		if err := cfg.validate(); err != nil {
+			return nil, fmt.Errorf("job %d of config '%s': %w", i+1, path, err)
+		}
+		if f.Parallel < 1 {
+			return nil, fmt.Errorf("job %d of config '%s': invalid Parallel %d", i+1, path, f.Parallel)
+		}
+		jobs = append(jobs, Job{Config: cfg, Parallel: f.Parallel})
+	}
+	return jobs, nil
+}
+
+// parseConfirmation is the reverse of confirmation
+func parseConfirmation(s string) (rune, error) {
+	switch s {
+	case "prompt":
+		return '-', nil
+	case "all":
+		return 'a', nil
+	case "none":
+		return 'x', nil
+	}
+	return 0, fmt.Errorf("invalid confirmation '%s', expected prompt, all or none", s)
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// wantJob are the fields of a job checked by TestLoadJobs
+type wantJob struct {
+	source, destination, verify string
+	deleteFile                  rune
+	parallel                    int
+}
+
+func TestLoadJobs(t *testing.T) {
+	a, b, c := t.TempDir(), t.TempDir(), t.TempDir()
+	missing := filepath.Join(a, "missing")
+	tests := []struct {
+		name    string
+		jobs    string
+		want    []wantJob
+		wantErr string
+	}{
+		{
+			name: "jobs in order",
+			jobs: fmt.Sprintf(`[{"Source": %q, "Destination": %q}, {"Source": %q, "Destination": %q, "Parallel": 2, "Verify": "full", "DeleteFile": "prompt"}]`, a, b, b, c),
+			want: []wantJob{{a, b, "none", 'a', 5}, {b, c, "full", '-', 2}},
+		},
+		{
+			name: "no-delete",
+			jobs: fmt.Sprintf(`[{"Source": %q, "Destination": %q, "NoDelete": true}]`, a, b),
+			want: []wantJob{{a, b, "none", 'x', 5}},
+		},
+		{name: "empty", jobs: `[]`},
+		{name: "not an array", jobs: `{}`, wantErr: "parse config"},
+		{name: "unknown field", jobs: `[{"Sauce": "x"}]`, wantErr: `unknown field "Sauce"`},
+		{name: "wrong type", jobs: `[{"Parallel": "2"}]`, wantErr: "job 1"},
+		{name: "no dirs", jobs: `[{"Verify": "full"}]`, wantErr: "Source and Destination are required"},
+		{name: "missing dir", jobs: fmt.Sprintf(`[{"Source": %q, "Destination": %q}]`, missing, b), wantErr: "not an existing directory"},
+		{name: "parallel", jobs: fmt.Sprintf(`[{"Source": %q, "Destination": %q, "Parallel": 0}]`, a, b), wantErr: "invalid Parallel 0"},
+		{name: "confirmation", jobs: fmt.Sprintf(`[{"Source": %q, "Destination": %q, "CreateDir": "always"}]`, a, b), wantErr: "invalid confirmation"},
+		{
+			name:    "enum in second job",
+			jobs:    fmt.Sprintf(`[{"Source": %q, "Destination": %q}, {"Source": %q, "Destination": %q, "CopyMethod": "rsync"}]`, a, b, b, c),
+			wantErr: "job 2 of config",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "jobs.json")
+			if err := os.WriteFile(path, []byte(tt.jobs), 0644); err != nil {
+				t.Fatal(err)
+			}
+			all := 'a'
+			base := testConfig()
+			base.CreateDir, base.DeleteDir, base.CreateFile, base.OverwriteFile, base.DeleteFile = &all, &all, &all, &all, &all
+			jobs, err := LoadJobs(path, base, 5)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadJobs() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadJobs() error = %v", err)
+			}
+			if len(jobs) != len(tt.want) {
+				t.Fatalf("%d jobs, want %d", len(jobs), len(tt.want))
+			}
+			for i, j := range jobs {
+				got := wantJob{j.Config.Source, j.Config.Destination, j.Config.Verify, *j.Config.DeleteFile, j.Parallel}
+				if got != tt.want[i] {
+					t.Errorf("job %d = %+v, want %+v", i+1, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
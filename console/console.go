@@ -4,71 +4,172 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/binChris/mirror/humanize"
 	"golang.org/x/term"
 )
 
 type Console struct {
 	waitForInput sync.Mutex
 	nextProgress time.Time
+	nextScanning time.Time
+	isTerminal   bool
+	canAsk       bool
+	byFiles      bool // the percentage counts files instead of bytes
+	totalFiles   int
+	totalBytes   int64
+	doneFiles    atomic.Int64
+	doneBytes    atomic.Int64
+	interval     time.Duration
+	quiet        bool
 }
 
-var oldTermState *term.State
+var (
+	termM        sync.Mutex
+	oldTermState *term.State
+)
+
+// makeRawTerm and restoreTerm change the terminal mode, replaced by tests
+var (
+	makeRawTerm = term.MakeRaw
+	restoreTerm = term.Restore
+)
+
+// ctrlC is read instead of a signal being sent while the terminal is in raw mode
+const ctrlC = 0x03
 
-func init() {
+// makeRaw switches the terminal to raw mode, so single key presses answer Choice. Cleanup restores it.
+// Raw mode turns off the signal keys, so it is only on while Choice reads
+func makeRaw() {
+	termM.Lock()
+	defer termM.Unlock()
+	if oldTermState != nil {
+		return
+	}
 	var err error
-	oldTermState, err = term.MakeRaw(int(os.Stdin.Fd()))
-	if err != nil {
-		fmt.Printf("Cannot switch to raw terminal mode: %s\n", err)
+	if oldTermState, err = makeRawTerm(int(os.Stdin.Fd())); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot switch to raw terminal mode: %s\n", err)
 	}
 }
 
+// Cleanup restores the terminal mode changed by Choice, e.g. after a panic. It can be called any number of times
 func Cleanup() {
+	termM.Lock()
+	defer termM.Unlock()
 	if oldTermState != nil {
-		term.Restore(int(os.Stdin.Fd()), oldTermState)
+		restoreTerm(int(os.Stdin.Fd()), oldTermState)
+		oldTermState = nil
 	}
 }
 
-func New() *Console {
-	return &Console{
+// New returns a Console showing max. 1 progress message per interval, none if quiet. With -progress the
+// percentage done counts the bytes or files, as given by percentBasis
+func New(interval time.Duration, quiet bool, percentBasis string) *Console {
+	c := &Console{
 		waitForInput: sync.Mutex{},
 		nextProgress: time.Now(),
+		nextScanning: time.Now(),
+		isTerminal:   term.IsTerminal(int(os.Stdout.Fd())),
+		canAsk:       term.IsTerminal(int(os.Stdin.Fd())),
+		interval:     interval,
+		quiet:        quiet,
+		byFiles:      percentBasis == "files",
 	}
+	return c
 }
 
-// Progress outputs max. 1 message per second. If waiting on input, output will be skipped
+// Progress outputs max. 1 message per interval. If waiting on input, output will be skipped
 func (c *Console) Progress(msg string) {
-	if c.nextProgress.After(time.Now()) {
+	if c.quiet || c.nextProgress.After(time.Now()) {
 		return
 	}
 	if !c.waitForInput.TryLock() {
 		return
 	}
 	defer c.waitForInput.Unlock()
-	c.nextProgress = time.Now().Add(time.Second)
+	c.nextProgress = time.Now().Add(c.interval)
+	switch {
+	case c.byFiles && c.totalFiles > 0:
+		done := c.doneFiles.Load()
+		fmt.Printf("%d%% (%d / %d files) ", done*100/int64(c.totalFiles), done, c.totalFiles)
+	case !c.byFiles && c.totalBytes > 0:
+		done := c.doneBytes.Load()
+		fmt.Printf("%d%% (%s / %s) ", done*100/c.totalBytes, humanize.Bytes(float64(done)), humanize.Bytes(float64(c.totalBytes)))
+	}
 	fmt.Println("...(", msg, ")")
 }
 
+// SetTotals sets the files and bytes which Progress shows the percentage done of. Must be called before mirroring
+func (c *Console) SetTotals(files int, bytes int64) {
+	c.totalFiles, c.totalBytes = files, bytes
+}
+
+// Completed updates the files and bytes done shown by Progress
+func (c *Console) Completed(files int, bytes int64) {
+	c.doneFiles.Store(int64(files))
+	c.doneBytes.Store(bytes)
+}
+
+// Action is not shown, Progress reports what is being done
+func (c *Console) Action(action, path string, bytes int64) {}
+
+// Scanning updates a counter of the scanned source entries in place, max. 10 times per second. Only shown on a terminal
+func (c *Console) Scanning(files, dirs uint64) {
+	if c.quiet || !c.isTerminal || c.nextScanning.After(time.Now()) {
+		return
+	}
+	if !c.waitForInput.TryLock() {
+		return
+	}
+	defer c.waitForInput.Unlock()
+	c.nextScanning = time.Now().Add(100 * time.Millisecond)
+	fmt.Printf("\rScanning... %s files, %s dirs", groupDigits(files), groupDigits(dirs))
+}
+
+// Fatal outputs the error which stopped the run
 func (c *Console) Fatal(msg string) {
 	fmt.Println("\n", msg)
-	os.Exit(1)
 }
 
+// Choice asks on the terminal until one of options is answered. Without a terminal to ask on, and on Ctrl-C, it returns q
 func (c *Console) Choice(msg string, options string) rune {
 	c.waitForInput.Lock()
 	defer c.waitForInput.Unlock()
+	if !c.canAsk {
+		fmt.Printf("\n %s: cannot ask, stdin is not a terminal; use -force, -yes or -no\n", msg)
+		return 'q'
+	}
+	makeRaw()
+	defer Cleanup()
 	for {
 		fmt.Print(msg, "? ")
 		b := make([]byte, 1)
-		_, _ = os.Stdin.Read(b)
+		if _, err := os.Stdin.Read(b); err != nil {
+			fmt.Print(err, "\r\n")
+			return 'q'
+		}
+		if b[0] == ctrlC {
+			fmt.Print("^C\r\n")
+			return 'q'
+		}
 		r := rune(b[0])
 		for _, o := range options {
 			if r == o {
-				fmt.Println(string(r))
+				fmt.Print(string(r), "\r\n")
 				return r
 			}
 		}
-		fmt.Println("Invalid answer")
+		fmt.Print("Invalid answer\r\n")
+	}
+}
+
+// groupDigits formats n with thousands separators
+func groupDigits(n uint64) string {
+	s := fmt.Sprint(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
 	}
+	return s
 }
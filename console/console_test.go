@@ -0,0 +1,185 @@
+package console
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/term"
+)
+
+func TestProgressPercentage(t *testing.T) {
+	tests := []struct {
+		name  string
+		basis string
+		want  string
+	}{
+		{name: "bytes", basis: "bytes", want: "75% (768 B / 1.0 KiB) ...( copying )"},
+		{name: "files", basis: "files", want: "25% (1 / 4 files) ...( copying )"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(0, false, tt.basis)
+			c.SetTotals(4, 1024)
+			c.Completed(1, 768)
+			out := captureStdout(t, func() { c.Progress("copying") })
+			if got := strings.TrimSpace(out); got != tt.want {
+				t.Errorf("Progress() printed %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProgressGating(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		quiet    bool
+		want     int // lines printed by 3 calls in a row
+	}{
+		{name: "quiet", quiet: true},
+		{name: "no interval", want: 3},
+		{name: "interval", interval: time.Hour, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(tt.interval, tt.quiet, "bytes")
+			out := captureStdout(t, func() {
+				for i := 0; i < 3; i++ {
+					c.Progress("copying")
+				}
+			})
+			if got := strings.Count(out, "...( copying )"); got != tt.want {
+				t.Errorf("printed %q, want %d lines", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestChoiceWithoutTerminal(t *testing.T) {
+	tests := []struct {
+		name  string
+		stdin func(t *testing.T) *os.File
+	}{
+		{
+			name: "closed",
+			stdin: func(t *testing.T) *os.File {
+				r, w, err := os.Pipe()
+				if err != nil {
+					t.Fatal(err)
+				}
+				w.Close()
+				return r
+			},
+		},
+		{
+			name: "null device",
+			stdin: func(t *testing.T) *os.File {
+				f, err := os.Open(os.DevNull)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return f
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.stdin(t)
+			defer f.Close()
+			stdin := os.Stdin
+			os.Stdin = f
+			defer func() { os.Stdin = stdin }()
+			c := New(0, true, "bytes")
+			answer := make(chan rune)
+			go func() { answer <- c.Choice("Delete 'a'", "ynaxq") }()
+			select {
+			case r := <-answer:
+				if r != 'q' {
+					t.Errorf("Choice() = %c, want q", r)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Choice() blocked")
+			}
+		})
+	}
+}
+
+func TestTerminalRestored(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string // on stdin, which is closed after it
+		run   func(c *Console) rune
+		want  rune
+	}{
+		{name: "answered", input: "y", run: func(c *Console) rune { return c.Choice("Delete 'a'", "ynaxq") }, want: 'y'},
+		{name: "ctrl-c", input: "\x03", run: func(c *Console) rune { return c.Choice("Delete 'a'", "ynaxq") }, want: 'q'},
+		{name: "stdin closed", run: func(c *Console) rune { return c.Choice("Delete 'a'", "ynaxq") }, want: 'q'},
+		{
+			// the deferred Cleanup of main
+			name: "panic",
+			run: func(c *Console) (r rune) {
+				defer func() { recover() }()
+				defer Cleanup()
+				makeRaw()
+				panic("failed")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw, restored int
+			defer func(m func(int) (*term.State, error), r func(int, *term.State) error) {
+				makeRawTerm, restoreTerm = m, r
+			}(makeRawTerm, restoreTerm)
+			makeRawTerm = func(int) (*term.State, error) {
+				raw++
+				return &term.State{}, nil
+			}
+			restoreTerm = func(int, *term.State) error {
+				restored++
+				return nil
+			}
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			w.WriteString(tt.input)
+			w.Close()
+			stdin := os.Stdin
+			os.Stdin = r
+			defer func() { os.Stdin = stdin }()
+			c := New(0, true, "bytes")
+			c.canAsk = true
+			var got rune
+			captureStdout(t, func() { got = tt.run(c) })
+			if got != tt.want {
+				t.Errorf("answer %q, want %q", got, tt.want)
+			}
+			// restoring again does nothing
+			Cleanup()
+			if raw != 1 || restored != 1 {
+				t.Errorf("raw mode set %d times, restored %d times, want once", raw, restored)
+			}
+		})
+	}
+}
+
+// captureStdout returns what fn prints to stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	fn()
+	os.Stdout = stdout
+	w.Close()
+	out, _ := io.ReadAll(r)
+	return string(out)
+}
@@ -0,0 +1,18 @@
+// Package humanize formats quantities for output
+package humanize
+
+import "fmt"
+
+// Bytes returns n with a binary unit, e.g. 1.5 GiB
+func Bytes(n float64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	i := -1
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", n, units[i])
+}
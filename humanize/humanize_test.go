@@ -0,0 +1,25 @@
+package humanize
+
+import "testing"
+
+func TestBytes(t *testing.T) {
+	tests := []struct {
+		n    float64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{3.2 * (1 << 30), "3.2 GiB"},
+		{80 << 20, "80.0 MiB"},
+		{1 << 40, "1.0 TiB"},
+		{1 << 60, "1.0 EiB"},
+		{1 << 70, "1024.0 EiB"},
+	}
+	for _, tt := range tests {
+		if got := Bytes(tt.n); got != tt.want {
+			t.Errorf("Bytes(%v) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Package jsonconsole is a mirror frontend writing newline-delimited JSON objects for a pipeline to consume
+package jsonconsole
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/binChris/mirror/mirror"
+)
+
+// JSON writes an object per action, per fatal error and a final summary. Progress messages are dropped.
+type JSON struct {
+	m   sync.Mutex
+	enc *json.Encoder
+}
+
+type action struct {
+	Event  string `json:"event"`
+	Action string `json:"action"`
+	Path   string `json:"path"`
+	Bytes  int64  `json:"bytes"`
+}
+
+type message struct {
+	Event   string `json:"event"`
+	Message string `json:"message"`
+}
+
+type summary struct {
+	Event          string  `json:"event"`
+	DirsCreated    uint64  `json:"dirs_created"`
+	DirsDeleted    uint64  `json:"dirs_deleted"`
+	FilesCopied    uint64  `json:"files_copied"`
+	FilesDeleted   uint64  `json:"files_deleted"`
+	FilesIdentical uint64  `json:"files_identical"`
+	BytesCopied    uint64  `json:"bytes_copied"`
+	Seconds        float64 `json:"duration_seconds"`
+	Error          string  `json:"error,omitempty"`
+}
+
+// New returns a frontend writing the JSON objects to w
+func New(w io.Writer) *JSON {
+	return &JSON{enc: json.NewEncoder(w)}
+}
+
+func (j *JSON) write(v any) {
+	j.m.Lock()
+	defer j.m.Unlock()
+	j.enc.Encode(v)
+}
+
+func (j *JSON) Progress(msg string) {}
+
+func (j *JSON) Scanning(files, dirs uint64) {}
+
+func (j *JSON) Fatal(msg string) {
+	j.write(message{Event: "error", Message: msg})
+}
+
+// Choice cannot ask, it quits the run
+func (j *JSON) Choice(msg string, options string) rune {
+	j.write(message{Event: "error", Message: msg + " cannot be confirmed in JSON mode"})
+	return 'q'
+}
+
+func (j *JSON) SetTotals(files int, bytes int64) {}
+
+func (j *JSON) Completed(files int, bytes int64) {}
+
+func (j *JSON) Action(act, path string, bytes int64) {
+	j.write(action{Event: "action", Action: act, Path: path, Bytes: bytes})
+}
+
+// Summary writes the final object with the counters of the run and its error, if any
+func (j *JSON) Summary(s mirror.Stats, err error) {
+	sum := summary{
+		Event:          "summary",
+		DirsCreated:    s.DirsCreated,
+		DirsDeleted:    s.DirsDeleted,
+		FilesCopied:    s.FilesCopied,
+		FilesDeleted:   s.FilesDeleted,
+		FilesIdentical: s.FilesIdentical,
+		BytesCopied:    s.BytesCopied,
+		Seconds:        s.Duration.Seconds(),
+	}
+	if err != nil {
+		sum.Error = err.Error()
+	}
+	j.write(sum)
+}
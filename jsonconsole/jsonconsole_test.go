@@ -0,0 +1,124 @@
+package jsonconsole
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/binChris/mirror/config"
+	"github.com/binChris/mirror/mirror"
+)
+
+// event holds the fields of all objects written
+type event struct {
+	Event       string `json:"event"`
+	Action      string `json:"action"`
+	Path        string `json:"path"`
+	Bytes       int64  `json:"bytes"`
+	Message     string `json:"message"`
+	FilesCopied uint64 `json:"files_copied"`
+	BytesCopied uint64 `json:"bytes_copied"`
+	Error       string `json:"error"`
+}
+
+func TestEventStream(t *testing.T) {
+	tests := []struct {
+		name        string
+		deleteFile  rune
+		wantActions []string
+		wantCopied  uint64
+		wantError   bool
+	}{
+		{
+			name:        "forced",
+			deleteFile:  'a',
+			wantActions: []string{"copy a 1", "copy sub/b 2", "delete-file orphan 0", "mkdir sub 0"},
+			wantCopied:  2,
+		},
+		{
+			// the prompt for the delete quits the run
+			name:       "prompt",
+			deleteFile: '-',
+			wantError:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			for name, content := range map[string]string{"a": "a", "sub/b": "bb"} {
+				write(t, filepath.Join(src, name), content)
+			}
+			write(t, filepath.Join(dst, "orphan"), "orphan")
+			all, del := 'a', tt.deleteFile
+			cfg := config.Config{
+				Source: src, Destination: dst,
+				CreateDir: &all, DeleteDir: &all, CreateFile: &all, OverwriteFile: &all, DeleteFile: &del,
+				OnCollision: "error", Umask: -1, ListFormat: "path", ListStyle: "tsv", CopyMethod: "auto",
+				Verify: "none", PercentBasis: "bytes", DeleteParallel: 1, CopyOrder: "any", Mtime: "preserve",
+				ScanParallel: 1, BlockSyncSize: 128 << 10, FileParallel: 1, FileParallelMin: 1 << 30,
+				BufferSize: 32 << 10, MtimeTolerance: time.Second, MaxDepth: -1, JSON: true,
+			}
+			var out bytes.Buffer
+			j := New(&out)
+			stats, err := mirror.Run(context.Background(), cfg, 1, j)
+			j.Summary(stats, err)
+
+			var actions []string
+			var last event
+			d := json.NewDecoder(&out)
+			for d.More() {
+				var e event
+				if err := d.Decode(&e); err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+				if last.Event == "summary" {
+					t.Errorf("%+v after the summary", e)
+				}
+				switch e.Event {
+				case "action":
+					rel, err := filepath.Rel(dst, e.Path)
+					if err != nil {
+						t.Fatal(err)
+					}
+					actions = append(actions, e.Action+" "+filepath.ToSlash(rel)+" "+fmt.Sprint(e.Bytes))
+				case "error", "summary":
+				default:
+					t.Errorf("unknown event %q", e.Event)
+				}
+				last = e
+			}
+			if last.Event != "summary" {
+				t.Fatalf("last event %+v, want the summary", last)
+			}
+			if (last.Error != "") != tt.wantError {
+				t.Errorf("summary error %q, want error %v", last.Error, tt.wantError)
+			}
+			if tt.wantError {
+				return
+			}
+			sort.Strings(actions)
+			if fmt.Sprint(actions) != fmt.Sprint(tt.wantActions) {
+				t.Errorf("actions %v, want %v", actions, tt.wantActions)
+			}
+			if last.FilesCopied != tt.wantCopied || last.BytesCopied != 3 {
+				t.Errorf("summary copied %d files, %d bytes", last.FilesCopied, last.BytesCopied)
+			}
+		})
+	}
+}
+
+func write(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
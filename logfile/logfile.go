@@ -0,0 +1,110 @@
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Writer is a concurrency-safe log file which is rotated by size. Each Write is kept in one file.
+type Writer struct {
+	m        sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	size     int64
+}
+
+// Open appends to the log file at path. When it would exceed maxSize bytes it is renamed to path.1,
+// older files are shifted up to path.(maxFiles). maxSize 0 disables rotation.
+func Open(path string, maxSize int64, maxFiles int) (*Writer, error) {
+	w := &Writer{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return fmt.Errorf("open log file '%s': %w", w.path, err)
+	}
+	inf, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("get file info for '%s': %w", w.path, err)
+	}
+	w.f = f
+	w.size = inf.Size()
+	return nil
+}
+
+// Write appends p to the log file. If the rotation fails, p is still written to the log file, which is no
+// longer rotated, and the error of the rotation is returned.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	var rotateErr error
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if rotateErr = w.rotate(); rotateErr != nil {
+			if w.f == nil {
+				return 0, rotateErr
+			}
+			// retrying would shift the rotated files again
+			w.maxSize = 0
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// rotate moves the log file out of the way and opens a new one. On failure the log file is opened again,
+// w.f is nil if that fails too.
+func (w *Writer) rotate() error {
+	err := w.f.Close()
+	w.f = nil
+	if err != nil {
+		return w.reopen(fmt.Errorf("close log file '%s': %w", w.path, err))
+	}
+	if w.maxFiles < 1 {
+		if err := os.Remove(w.path); err != nil {
+			return w.reopen(fmt.Errorf("remove log file '%s': %w", w.path, err))
+		}
+		return w.open()
+	}
+	os.Remove(fmt.Sprintf("%s.%d", w.path, w.maxFiles))
+	for i := w.maxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+	}
+	if err := os.Rename(w.path, w.path+".1"); err != nil {
+		return w.reopen(fmt.Errorf("rotate log file '%s': %w", w.path, err))
+	}
+	return w.open()
+}
+
+// reopen opens the log file again after the rotation failed with err, which it returns
+func (w *Writer) reopen(err error) error {
+	if oerr := w.open(); oerr != nil {
+		return fmt.Errorf("%w; %w", err, oerr)
+	}
+	return err
+}
+
+func (w *Writer) Close() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	return w.f.Close()
+}
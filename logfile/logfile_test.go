@@ -0,0 +1,151 @@
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxSize  int64
+		maxFiles int
+		setup    func(t *testing.T, path string)
+		want     map[string]string // content by file name
+		wantErr  bool              // of a write
+	}{
+		{
+			name:     "no rotation",
+			maxFiles: 2,
+			want:     map[string]string{"log": "line 1\nline 2\nline 3\n"},
+		},
+		{
+			name:     "rotated",
+			maxSize:  14,
+			maxFiles: 2,
+			want:     map[string]string{"log": "line 3\n", "log.1": "line 1\nline 2\n"},
+		},
+		{
+			name:     "oldest dropped",
+			maxSize:  7,
+			maxFiles: 1,
+			want:     map[string]string{"log": "line 3\n", "log.1": "line 2\n"},
+		},
+		{
+			name:    "no files kept",
+			maxSize: 7,
+			want:    map[string]string{"log": "line 3\n"},
+		},
+		{
+			name:     "rotation fails",
+			maxSize:  7,
+			maxFiles: 1,
+			setup: func(t *testing.T, path string) {
+				// a dir which is not empty cannot be replaced by the log file
+				if err := os.MkdirAll(filepath.Join(path+".1", "x"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:    map[string]string{"log": "line 1\nline 2\nline 3\n"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "log")
+			if tt.setup != nil {
+				tt.setup(t, path)
+			}
+			w, err := Open(path, tt.maxSize, tt.maxFiles)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var errs int
+			for _, line := range []string{"line 1\n", "line 2\n", "line 3\n"} {
+				n, err := w.Write([]byte(line))
+				if err != nil {
+					errs++
+				}
+				if n != len(line) {
+					t.Errorf("Write(%q) = %d, %v", line, n, err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if (errs > 0) != tt.wantErr || errs > 1 {
+				t.Errorf("%d writes failed, wantErr %v", errs, tt.wantErr)
+			}
+			for name, want := range tt.want {
+				b, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != want {
+					t.Errorf("%s = %q, want %q", name, b, want)
+				}
+			}
+			ee, _ := os.ReadDir(dir)
+			var names []string
+			for _, e := range ee {
+				if !e.IsDir() {
+					names = append(names, e.Name())
+				}
+			}
+			if len(names) != len(tt.want) {
+				t.Errorf("files %s, want %d", strings.Join(names, ", "), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestWriterConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "log")
+	w, err := Open(path, 1<<10, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const writers, lines = 8, 100
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < lines; j++ {
+				fmt.Fprintf(w, "writer %d line %03d\n", i, j)
+			}
+		}(i)
+	}
+	wg.Wait()
+	w.Close()
+	ee, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, e := range ee {
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) > 1<<10 {
+			t.Errorf("%s has %d bytes", e.Name(), len(b))
+		}
+		for _, l := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+			var i, j int
+			if _, err := fmt.Sscanf(l, "writer %d line %d", &i, &j); err != nil || seen[l] {
+				t.Fatalf("broken or repeated line %q in %s", l, e.Name())
+			}
+			seen[l] = true
+		}
+	}
+	if len(seen) != writers*lines {
+		t.Errorf("%d lines logged, want %d", len(seen), writers*lines)
+	}
+}
@@ -1,13 +1,144 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/binChris/mirror/config"
 	"github.com/binChris/mirror/console"
+	"github.com/binChris/mirror/jsonconsole"
+	"github.com/binChris/mirror/logfile"
 	"github.com/binChris/mirror/mirror"
 )
 
+// exitCodes maps the outcomes of a run to the process exit code
+var exitCodes = []struct {
+	err   error
+	code  int
+	rsync int
+}{
+	{mirror.ErrTimeLimit, 2, 30},
+	{mirror.ErrMismatch, 3, 23},
+	{mirror.ErrFilesChanged, 4, 24},
+	{mirror.ErrPlanDrift, 5, 23},
+	{mirror.ErrReserve, 6, 11},
+	{mirror.ErrScanErrors, 7, 23},
+	{mirror.ErrSourceGone, 8, 23},
+	{mirror.ErrFailures, 9, 23},
+	{mirror.ErrFatal, 1, 23},
+	{mirror.ErrQuit, 1, 20},
+	{context.Canceled, 130, 20},
+}
+
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
 	defer console.Cleanup()
 	cfg, parallel := config.FromCommandLine()
-	mirror.Run(cfg, parallel, console.New())
+	jobs := []config.Job{{Config: cfg, Parallel: parallel}}
+	if cfg.ConfigFile != "" {
+		var err error
+		if jobs, err = config.LoadJobs(cfg.ConfigFile, cfg, parallel); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+	out := os.Stdout
+	if cfg.JSON {
+		// only the JSON objects go to stdout, other output of the run to stderr or nowhere
+		os.Stdout = os.Stderr
+		if cfg.Quiet {
+			devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+			if err != nil {
+				fmt.Println(err)
+				return 1
+			}
+			defer devNull.Close()
+			os.Stdout = devNull
+		}
+	}
+	// stop starting new work on a signal, the deferred cleanups still run
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// the exit code is the one of the first failed job
+	code := 0
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		if c := runJob(ctx, job.Config, job.Parallel, out); code == 0 {
+			code = c
+		}
+	}
+	return code
+}
+
+func runJob(ctx context.Context, cfg config.Config, parallel int, out *os.File) int {
+	if cfg.SnapshotCmd != "" {
+		if err := runHook(cfg.SnapshotCmd, cfg); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+	if cfg.SnapshotCleanupCmd != "" {
+		defer func(cfg config.Config) {
+			if err := runHook(cfg.SnapshotCleanupCmd, cfg); err != nil {
+				fmt.Println(err)
+			}
+		}(cfg)
+	}
+	if cfg.SnapshotMount != "" {
+		cfg.Source = cfg.SnapshotMount
+	}
+	var frontend mirror.Frontend = console.New(cfg.ProgressInterval, cfg.Quiet, cfg.PercentBasis)
+	var j *jsonconsole.JSON
+	if cfg.JSON {
+		j = jsonconsole.New(out)
+		frontend = j
+	}
+	if cfg.ProgressLog != "" {
+		w, err := logfile.Open(cfg.ProgressLog, cfg.LogMaxSize, cfg.LogMaxFiles)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		defer w.Close()
+		frontend = mirror.WithLog(frontend, w)
+	}
+	if cfg.Log != "" {
+		w, err := logfile.Open(cfg.Log, cfg.LogMaxSize, cfg.LogMaxFiles)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		defer w.Close()
+		frontend = mirror.WithLogger(frontend, mirror.NewLogger(w))
+	}
+	stats, err := mirror.Run(ctx, cfg, parallel, frontend)
+	if j != nil {
+		j.Summary(stats, err)
+	}
+	if err == nil {
+		return 0
+	}
+	for _, ec := range exitCodes {
+		if errors.Is(err, ec.err) {
+			if cfg.RsyncExitCodes {
+				return ec.rsync
+			}
+			return ec.code
+		}
+	}
+	fmt.Println(err)
+	if cfg.RsyncExitCodes {
+		// file I/O error
+		return 11
+	}
+	return 1
 }
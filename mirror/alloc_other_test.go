@@ -0,0 +1,7 @@
+//go:build !unix
+
+package mirror
+
+func allocatedBytes(path string) (int64, bool) {
+	return 0, false
+}
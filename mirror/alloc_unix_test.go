@@ -0,0 +1,21 @@
+//go:build unix
+
+package mirror
+
+import (
+	"os"
+	"syscall"
+)
+
+// allocatedBytes returns the bytes allocated on disk for the file at path, false if unknown
+func allocatedBytes(path string) (int64, bool) {
+	inf, err := os.Stat(path)
+	if err != nil {
+		return 0, false
+	}
+	st, ok := inf.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int64(st.Blocks) * 512, true
+}
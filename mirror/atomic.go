@@ -0,0 +1,92 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/binChris/mirror/config"
+)
+
+// runAtomic mirrors into a temporary sibling of the destination and swaps it in when done,
+// so the destination is never seen half-updated. The sibling starts as a tree of hard links to the
+// current destination, so unchanged files are neither copied nor compared by content, and deletes
+// are asked for, skipped with -no-delete and spare excluded entries like in a normal run.
+// On Linux the dirs are exchanged atomically; elsewhere the destination is missing between two renames.
+func runAtomic(ctx context.Context, cfg config.Config, parallel int, frontend Frontend) (Stats, error) {
+	final := cfg.Destination
+	tmp := final + ".tmp"
+	old := final + ".old"
+	// remove leftovers of a failed run
+	if err := os.RemoveAll(tmp); err != nil {
+		return Stats{}, fmt.Errorf("remove '%s': %w", tmp, err)
+	}
+	if err := linkTree(final, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return Stats{}, fmt.Errorf("link '%s' to '%s': %w", final, tmp, err)
+	}
+	cfg.Destination = tmp
+	// writing in place would change the linked files of the destination
+	cfg.Inplace, cfg.BlockSync = false, false
+	stats, err := run(ctx, cfg, parallel, frontend, OS)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return stats, err
+	}
+	err = exchange(tmp, final)
+	if err == nil {
+		// tmp holds the old destination now
+		if err := os.RemoveAll(tmp); err != nil {
+			return stats, fmt.Errorf("remove '%s': %w", tmp, err)
+		}
+		return stats, nil
+	}
+	if !errors.Is(err, errUnsupported) {
+		os.RemoveAll(tmp)
+		return stats, fmt.Errorf("exchange '%s' and '%s': %w", tmp, final, err)
+	}
+	if err := os.RemoveAll(old); err != nil {
+		return stats, fmt.Errorf("remove '%s': %w", old, err)
+	}
+	if err := os.Rename(final, old); err != nil {
+		os.RemoveAll(tmp)
+		return stats, fmt.Errorf("rename '%s': %w", final, err)
+	}
+	if err := os.Rename(tmp, final); err != nil {
+		os.Rename(old, final)
+		os.RemoveAll(tmp)
+		return stats, fmt.Errorf("rename '%s': %w", tmp, err)
+	}
+	if err := os.RemoveAll(old); err != nil {
+		return stats, fmt.Errorf("remove '%s': %w", old, err)
+	}
+	return stats, nil
+}
+
+// linkTree recreates the dirs and symlinks of the tree at src in dst and hard-links its files
+func linkTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case e.IsDir():
+			inf, err := e.Info()
+			if err != nil {
+				return err
+			}
+			return os.Mkdir(target, inf.Mode().Perm()|0700)
+		case e.Type()&fs.ModeSymlink != 0:
+			return copySymlink(OS, path, target)
+		}
+		return os.Link(path, target)
+	})
+}
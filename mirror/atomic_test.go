@@ -0,0 +1,89 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/binChris/mirror/config"
+)
+
+func TestRunAtomic(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(cfg *config.Config)
+		answer rune
+		want   map[string]string
+	}{
+		{
+			name: "forced",
+			want: map[string]string{"same": "same", "changed": "new", "new": "new"},
+		},
+		{
+			name:  "no delete",
+			setup: func(cfg *config.Config) { no := 'x'; cfg.DeleteFile = &no },
+			want:  map[string]string{"same": "same", "changed": "new", "new": "new", "orphan": "orphan", "app.log": "log"},
+		},
+		{
+			name:   "delete declined",
+			setup:  func(cfg *config.Config) { ask := '-'; cfg.DeleteFile = &ask },
+			answer: 'n',
+			want:   map[string]string{"same": "same", "changed": "new", "new": "new", "orphan": "orphan", "app.log": "log"},
+		},
+		{
+			name:  "excluded kept",
+			setup: func(cfg *config.Config) { cfg.Filters = []config.FilterRule{{Pattern: "*.log"}} },
+			want:  map[string]string{"same": "same", "changed": "new", "new": "new", "app.log": "log"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dir := t.TempDir(), t.TempDir()
+			dst := filepath.Join(dir, "dst")
+			writeFile(t, src, "same", "same")
+			writeFile(t, src, "changed", "new")
+			writeFile(t, src, "new", "new")
+			writeFile(t, dst, "same", "same")
+			writeFile(t, dst, "changed", "old content")
+			writeFile(t, dst, "orphan", "orphan")
+			writeFile(t, dst, "app.log", "log")
+			same, err := os.Stat(filepath.Join(src, "same"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(filepath.Join(dst, "same"), same.ModTime(), same.ModTime()); err != nil {
+				t.Fatal(err)
+			}
+			cfg := testConfig(src, dst)
+			cfg.AtomicDir = true
+			if tt.setup != nil {
+				tt.setup(&cfg)
+			}
+			f := &testFrontend{answer: tt.answer}
+			if _, err := Run(context.Background(), cfg, 2, f); err != nil {
+				t.Fatalf("Run() error = %v, fatal %v", err, f.fatal)
+			}
+			ee, err := os.ReadDir(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for _, e := range ee {
+				got[e.Name()] = readFile(t, filepath.Join(dst, e.Name()))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("destination = %v, want %v", got, tt.want)
+			}
+			if left, _ := filepath.Glob(dst + ".*"); len(left) > 0 {
+				t.Errorf("left behind: %v", left)
+			}
+			for _, a := range f.actions {
+				if a == "link "+filepath.Join(dst+".tmp", "same") || a == "copy "+filepath.Join(dst+".tmp", "same") {
+					t.Errorf("unchanged file transferred: %s", a)
+				}
+			}
+		})
+	}
+}
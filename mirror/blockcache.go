@@ -0,0 +1,94 @@
+package mirror
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// The block hashes of -block-sync-cache are kept in a file per destination file in the cache dir, named
+// by the hash of its absolute path. It starts with a line of the size, mtime and block size of the
+// destination file when written, followed by the sha256 of each block. It is only used while the
+// destination file still has that size and mtime.
+
+// blockCachePath returns the path of the block hashes of the destination file dst in dir
+func blockCachePath(dir, dst string) (string, error) {
+	abs, err := filepath.Abs(dst)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(dir, hex.EncodeToString(sum[:16])), nil
+}
+
+// loadBlockHashes returns the cached hashes of the blocks of size bs of dst, nil if there are none or
+// dst changed since they were written
+func loadBlockHashes(dir, dst string, bs int64) [][]byte {
+	p, err := blockCachePath(dir, dst)
+	if err != nil {
+		return nil
+	}
+	inf, err := os.Stat(dst)
+	if err != nil {
+		return nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var size, mtime, cachedBs int64
+	if _, err := fmt.Fscanf(r, "%d %d %d\n", &size, &mtime, &cachedBs); err != nil ||
+		size != inf.Size() || mtime != inf.ModTime().UnixNano() || cachedBs != bs {
+		return nil
+	}
+	var hashes [][]byte
+	for {
+		h := make([]byte, sha256.Size)
+		if _, err := io.ReadFull(r, h); err != nil {
+			if err == io.EOF && int64(len(hashes)) == (size+bs-1)/bs {
+				return hashes
+			}
+			return nil
+		}
+		hashes = append(hashes, h)
+	}
+}
+
+// saveBlockHashes writes the hashes of the blocks of size bs of dst to the cache in dir
+func saveBlockHashes(dir, dst string, bs int64, hashes [][]byte) error {
+	p, err := blockCachePath(dir, dst)
+	if err != nil {
+		return err
+	}
+	inf, err := os.Stat(dst)
+	if err != nil {
+		return fmt.Errorf("get file info for '%s': %w", dst, err)
+	}
+	f, err := os.CreateTemp(dir, ".blocks.*.tmp")
+	if err != nil {
+		return fmt.Errorf("write block cache for '%s': %w", dst, err)
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "%d %d %d\n", inf.Size(), inf.ModTime().UnixNano(), bs)
+	for _, h := range hashes {
+		w.Write(h)
+	}
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		return fmt.Errorf("write block cache for '%s': %w", dst, err)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package mirror
+
+import "os"
+
+// blockSize assumes the common block size where it cannot be determined
+func blockSize(f *os.File) int64 {
+	return 4096
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin
+
+package mirror
+
+import (
+	"os"
+	"syscall"
+)
+
+// blockSize returns the block size of the filesystem f is on
+func blockSize(f *os.File) int64 {
+	var st syscall.Statfs_t
+	if err := syscall.Fstatfs(int(f.Fd()), &st); err != nil || st.Bsize <= 0 {
+		return 4096
+	}
+	return int64(st.Bsize)
+}
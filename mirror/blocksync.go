@@ -0,0 +1,45 @@
+package mirror
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+)
+
+// syncBlocks updates dst in place to the content read from src, comparing blocks of size bs
+// and writing only those which differ. dst is truncated to the size of src. known are the hashes of the
+// blocks of dst from the previous sync, blocks of src with the same hash are not read from dst.
+// The hashes of the new blocks of dst are returned.
+func syncBlocks(dst *os.File, src io.Reader, bs int64, known [][]byte) ([][]byte, error) {
+	sBuf := make([]byte, bs)
+	dBuf := make([]byte, bs)
+	var off int64
+	var hashes [][]byte
+	for i := 0; ; i++ {
+		n, err := io.ReadFull(src, sBuf)
+		if n > 0 {
+			h := sha256.Sum256(sBuf[:n])
+			hashes = append(hashes, h[:])
+			if i >= len(known) || !bytes.Equal(known[i], h[:]) {
+				d, dErr := dst.ReadAt(dBuf[:n], off)
+				if dErr != nil && dErr != io.EOF {
+					return nil, dErr
+				}
+				if d < n || !bytes.Equal(sBuf[:n], dBuf[:n]) {
+					if _, err := dst.WriteAt(sBuf[:n], off); err != nil {
+						return nil, err
+					}
+				}
+			}
+			off += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hashes, dst.Truncate(off)
+}
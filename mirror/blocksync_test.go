@@ -0,0 +1,125 @@
+package mirror
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncBlocks(t *testing.T) {
+	hashes := func(blocks ...string) [][]byte {
+		var h [][]byte
+		for _, b := range blocks {
+			sum := sha256.Sum256([]byte(b))
+			h = append(h, sum[:])
+		}
+		return h
+	}
+	tests := []struct {
+		name     string
+		src, dst string
+		known    [][]byte
+		want     string
+	}{
+		{name: "changed block", src: "aaaabbbbcc", dst: "aaaaxxxxcc", want: "aaaabbbbcc"},
+		{name: "shorter", src: "aaaabb", dst: "aaaabbbbcc", want: "aaaabb"},
+		{name: "longer", src: "aaaabbbbcc", dst: "aaaa", want: "aaaabbbbcc"},
+		{name: "empty", src: "", dst: "aaaa", want: ""},
+		{name: "stale hashes", src: "aaaabbbbcc", dst: "aaaaxxxxcc", known: hashes("aaaa", "yyyy", "cc"), want: "aaaabbbbcc"},
+		// blocks whose hash is known are trusted and not read from the destination
+		{name: "known hashes", src: "aaaabbbbcc", dst: "aaaaxxxxcc", known: hashes("aaaa", "bbbb", "cc"), want: "aaaaxxxxcc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := writeFile(t, t.TempDir(), "dst", tt.dst)
+			f, err := os.OpenFile(p, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			got, err := syncBlocks(f, strings.NewReader(tt.src), 4, tt.known)
+			if err != nil {
+				t.Fatalf("syncBlocks() error = %v", err)
+			}
+			if content := readFile(t, p); content != tt.want {
+				t.Errorf("destination = %q, want %q", content, tt.want)
+			}
+			var blocks []string
+			for s := tt.src; s != ""; {
+				n := 4
+				if len(s) < n {
+					n = len(s)
+				}
+				blocks = append(blocks, s[:n])
+				s = s[n:]
+			}
+			if want := hashes(blocks...); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("hashes = %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+func TestBlockCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, dst string)
+		bs     int64
+		want   bool
+	}{
+		{name: "unchanged", bs: 4, want: true},
+		{name: "other block size", bs: 8},
+		{
+			name: "destination changed",
+			bs:   4,
+			change: func(t *testing.T, dst string) {
+				if err := os.Chtimes(dst, time.Now(), time.Now().Add(time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{name: "destination removed", bs: 4, change: func(t *testing.T, dst string) { os.Remove(dst) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := t.TempDir()
+			dst := writeFile(t, t.TempDir(), "dst", "aaaabbbbcc")
+			h := sha256.Sum256([]byte("x"))
+			if err := saveBlockHashes(cache, dst, 4, [][]byte{h[:], h[:], h[:]}); err != nil {
+				t.Fatal(err)
+			}
+			if tmps, _ := filepath.Glob(filepath.Join(cache, "*.tmp")); len(tmps) > 0 {
+				t.Errorf("temporary files left: %v", tmps)
+			}
+			if tt.change != nil {
+				tt.change(t, dst)
+			}
+			if got := loadBlockHashes(cache, dst, tt.bs); (len(got) == 3) != tt.want {
+				t.Errorf("loaded %d hashes, want them %v", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestCopyFileBlockCache(t *testing.T) {
+	dir, cache := t.TempDir(), t.TempDir()
+	src := writeFile(t, dir, "src", "aaaabbbbcc")
+	dst := writeFile(t, dir, "dst", "aaaaxxxx")
+	opts := copyOptions{method: "read-write", umask: -1, inplace: true, blockSize: 4, blockCache: cache, mtime: "preserve"}
+	for i, content := range []string{"aaaabbbbcc", "aaaaBBBBcc"} {
+		writeFile(t, dir, "src", content)
+		if err := copyFile(OS, src, dst, opts); err != nil {
+			t.Fatalf("copy %d: copyFile() error = %v", i+1, err)
+		}
+		if got := readFile(t, dst); got != content {
+			t.Errorf("copy %d: destination = %q, want %q", i+1, got, content)
+		}
+		if got := loadBlockHashes(cache, dst, 4); len(got) != 3 {
+			t.Errorf("copy %d: %d hashes cached", i+1, len(got))
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package mirror
+
+import "sync"
+
+// defaultBufferSize is the copy buffer size if none is configured, the same as io.Copy uses
+const defaultBufferSize = 32 * 1024
+
+// bufferPools holds a *sync.Pool of copy buffers per buffer size, so parallel copies reuse them
+var bufferPools sync.Map
+
+// getBuffer returns a buffer of size bytes, to be returned with putBuffer
+func getBuffer(size int) *[]byte {
+	if size < 1 {
+		size = defaultBufferSize
+	}
+	p, ok := bufferPools.Load(size)
+	if !ok {
+		p, _ = bufferPools.LoadOrStore(size, &sync.Pool{New: func() any {
+			b := make([]byte, size)
+			return &b
+		}})
+	}
+	return p.(*sync.Pool).Get().(*[]byte)
+}
+
+func putBuffer(b *[]byte) {
+	if p, ok := bufferPools.Load(len(*b)); ok {
+		p.(*sync.Pool).Put(b)
+	}
+}
@@ -0,0 +1,27 @@
+package mirror
+
+import "testing"
+
+func TestGetBuffer(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		want int
+	}{
+		{name: "default", size: 0, want: defaultBufferSize},
+		{name: "small", size: 4 << 10, want: 4 << 10},
+		{name: "large", size: 1 << 20, want: 1 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a buffer returned to the pool is reused at the same size
+			for i := 0; i < 2; i++ {
+				b := getBuffer(tt.size)
+				if len(*b) != tt.want {
+					t.Fatalf("len = %d, want %d", len(*b), tt.want)
+				}
+				putBuffer(b)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package mirror
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// matchCase renames the destination entries whose names differ only in case from a source entry to the source name,
+// so they are compared instead of deleted and created again. Of source names differing only in case all but the
+// first are dropped and reported, as they would overwrite each other on a case-insensitive destination.
+func (m *mirror) matchCase(src, dst string, sDirs, sFiles, dDirs, dFiles map[string]fs.DirEntry) {
+	names := make([]string, 0, len(sDirs)+len(sFiles))
+	for _, entries := range []map[string]fs.DirEntry{sDirs, sFiles} {
+		for name := range entries {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	byLower := make(map[string]string, len(names))
+	for _, name := range names {
+		lower := strings.ToLower(name)
+		if first, ok := byLower[lower]; ok {
+			m.frontend.Progress(fmt.Sprintf("Warning: skipping %s, its name differs only in case from %s", filepath.Join(src, name), first))
+			atomic.AddUint64(&m.caseCollisions, 1)
+			delete(sDirs, name)
+			delete(sFiles, name)
+			continue
+		}
+		byLower[lower] = name
+	}
+	for _, entries := range []map[string]fs.DirEntry{dDirs, dFiles} {
+		for name, e := range entries {
+			target, ok := byLower[strings.ToLower(name)]
+			if !ok || target == name {
+				continue
+			}
+			if _, taken := entries[target]; taken {
+				continue
+			}
+			// plans and dry runs leave the destination alone
+			if m.plan == nil && !m.dryRun {
+				from, to := filepath.Join(dst, name), filepath.Join(dst, target)
+				m.frontend.Progress(fmt.Sprintf("Renaming %s to %s", from, to))
+				if err := m.fs.Rename(from, to); err != nil {
+					m.fail(fmt.Sprintf("Cannot rename '%s' to '%s': %s", from, to, err))
+					continue
+				}
+			}
+			delete(entries, name)
+			entries[target] = e
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIgnoreCase(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name        string
+		ignoreCase  bool
+		src, dst    []string
+		want        []string
+		wantTree    map[string]string
+		wantWarning bool
+	}{
+		{
+			name:     "case sensitive",
+			src:      []string{"Foo.txt"},
+			dst:      []string{"foo.txt"},
+			want:     []string{"copy /d/Foo.txt", "delete-file /d/foo.txt"},
+			wantTree: map[string]string{"Foo.txt": "Foo.txt"},
+		},
+		{
+			name:       "differs only in case",
+			ignoreCase: true,
+			src:        []string{"Foo.txt", "Sub/a"},
+			dst:        []string{"foo.txt", "sub/a"},
+			want:       []string{"identical /d/Foo.txt", "identical /d/Sub/a"},
+			wantTree:   map[string]string{"Foo.txt": "Foo.txt", "Sub/": "", "Sub/a": "Sub/a"},
+		},
+		{
+			name:        "source collision",
+			ignoreCase:  true,
+			src:         []string{"Foo.txt", "foo.txt"},
+			want:        []string{"copy /d/Foo.txt"},
+			wantTree:    map[string]string{"Foo.txt": "Foo.txt"},
+			wantWarning: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := newMemFS()
+			if err := fsys.Mkdir("/d", 0755); err != nil {
+				t.Fatal(err)
+			}
+			// the destination content is that of the source name, so renamed files are identical
+			for i, p := range tt.src {
+				fsys.file("/s/"+p, p, mtime)
+				if i < len(tt.dst) {
+					fsys.file("/d/"+tt.dst[i], p, mtime)
+				}
+			}
+			cfg := testConfig("/s", "/d")
+			cfg.IgnoreCase = tt.ignoreCase
+			f := &testFrontend{}
+			if _, err := RunFS(context.Background(), cfg, 1, f, fsys); err != nil {
+				t.Fatalf("RunFS() error = %v, fatal %v", err, f.fatal)
+			}
+			if got := f.sortedActions(); !stringsEqual(got, tt.want) {
+				t.Errorf("actions = %v, want %v", got, tt.want)
+			}
+			if got := fsys.tree("/d"); fmt.Sprint(got) != fmt.Sprint(tt.wantTree) {
+				t.Errorf("destination = %v, want %v", got, tt.wantTree)
+			}
+			warned := strings.Contains(fmt.Sprint(f.progress), "Warning: skipping /s/foo.txt, its name differs only in case from Foo.txt")
+			if warned != tt.wantWarning {
+				t.Errorf("collision warned %v, want %v", warned, tt.wantWarning)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package mirror
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// compareChunk is the size of the blocks compared at a time, a difference stops the comparison
+const compareChunk = 1 << 20
+
+// equalContent compares two files of equal size. With -mmap-compare the bytes are compared directly,
+// stopping at the first difference, otherwise the hashes of both files are compared.
+func (m *mirror) equalContent(a, b string, size int64) (bool, error) {
+	if !m.mmapCompare {
+		aHash, err := hashFile(m.fs, a)
+		if err != nil {
+			return false, err
+		}
+		bHash, err := hashFile(m.fs, b)
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(aHash, bHash), nil
+	}
+	if size >= m.mmapMin {
+		if same, err := mmapEqual(a, b, size); err == nil {
+			return same, nil
+		}
+		// e.g. unsupported platform or filesystem, fall back to reading
+	}
+	return streamEqual(a, b)
+}
+
+// streamEqual reads both files chunk by chunk and stops at the first difference
+func streamEqual(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, fmt.Errorf("Could not open '%s' for reading", a)
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, fmt.Errorf("Could not open '%s' for reading", b)
+	}
+	defer fb.Close()
+	bufA := make([]byte, compareChunk)
+	bufB := make([]byte, compareChunk)
+	for {
+		nA, errA := io.ReadFull(fa, bufA)
+		nB, errB := io.ReadFull(fb, bufB)
+		if !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, fmt.Errorf("error reading file '%s': %s", a, errA)
+		}
+		if errB != nil {
+			return false, fmt.Errorf("error reading file '%s': %s", b, errB)
+		}
+	}
+}
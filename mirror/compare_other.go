@@ -0,0 +1,7 @@
+//go:build !unix
+
+package mirror
+
+func mmapEqual(a, b string, size int64) (bool, error) {
+	return false, errUnsupported
+}
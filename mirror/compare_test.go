@@ -0,0 +1,152 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// BenchmarkEqualContent compares large files differing near the start or not at all, by hash and by -mmap-compare
+func BenchmarkEqualContent(b *testing.B) {
+	const size = 64 << 20
+	dir := b.TempDir()
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	files := map[string][]byte{"a": data, "same": data}
+	early := append([]byte(nil), data...)
+	early[100] ^= 1
+	files["early"] = early
+	for name, d := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), d, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for _, bm := range []struct {
+		name, other string
+		mmap        bool
+	}{
+		{"hash/early difference", "early", false},
+		{"mmap/early difference", "early", true},
+		{"hash/identical", "same", false},
+		{"mmap/identical", "same", true},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			m := &mirror{fs: OS, mmapCompare: bm.mmap}
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				if _, err := m.equalContent(filepath.Join(dir, "a"), filepath.Join(dir, bm.other), size); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSizeComparedFirst(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name       string
+		dst        string
+		wantAction string
+		wantOpens  bool
+	}{
+		{name: "size differs", dst: "longer", wantAction: "overwrite /d/f"},
+		{name: "content differs", dst: "b", wantAction: "overwrite /d/f", wantOpens: true},
+		{name: "identical", dst: "a", wantAction: "identical /d/f", wantOpens: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := newCountingFS()
+			fsys.file("/s/f", "a", mtime)
+			fsys.file("/d/f", tt.dst, mtime)
+			cfg := testConfig("/s", "/d")
+			cfg.Checksum, cfg.DryRun = true, true
+			f := &testFrontend{}
+			if _, err := RunFS(context.Background(), cfg, 1, f, fsys); err != nil {
+				t.Fatalf("RunFS() error = %v, fatal %v", err, f.fatal)
+			}
+			if got := f.sortedActions(); !stringsEqual(got, []string{tt.wantAction}) {
+				t.Errorf("actions = %v, want %s", got, tt.wantAction)
+			}
+			if opened := len(fsys.opens) > 0; opened != tt.wantOpens {
+				t.Errorf("files opened %v, want %v", fsys.opens, tt.wantOpens)
+			}
+		})
+	}
+}
+
+func TestChecksumMode(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name     string
+		checksum bool
+		want     []string
+	}{
+		{name: "size and mtime", want: []string{"identical /d/f"}},
+		{name: "checksum", checksum: true, want: []string{"overwrite /d/f"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := newMemFS()
+			fsys.file("/s/f", "abc", mtime)
+			fsys.file("/d/f", "xyz", mtime)
+			cfg := testConfig("/s", "/d")
+			cfg.Checksum = tt.checksum
+			f := &testFrontend{}
+			if _, err := RunFS(context.Background(), cfg, 1, f, fsys); err != nil {
+				t.Fatalf("RunFS() error = %v, fatal %v", err, f.fatal)
+			}
+			if got := f.sortedActions(); !stringsEqual(got, tt.want) {
+				t.Errorf("actions = %v, want %v", got, tt.want)
+			}
+			want := map[string]string{"f": "xyz"}
+			if tt.checksum {
+				want["f"] = "abc"
+			}
+			if got := fsys.tree("/d"); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("destination = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestMtimeTolerance(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		tolerance time.Duration
+		offset    time.Duration // of the destination mtime
+		want      string
+	}{
+		{name: "equal", tolerance: time.Second, want: "identical"},
+		{name: "inside", tolerance: time.Second, offset: time.Second - 1, want: "identical"},
+		{name: "at the limit", tolerance: time.Second, offset: time.Second, want: "identical"},
+		{name: "outside", tolerance: time.Second, offset: time.Second + 1, want: "overwrite"},
+		{name: "older inside", tolerance: time.Second, offset: -time.Second, want: "identical"},
+		{name: "older outside", tolerance: time.Second, offset: -time.Second - 1, want: "overwrite"},
+		{name: "FAT inside", tolerance: 2 * time.Second, offset: 2 * time.Second, want: "identical"},
+		{name: "FAT outside", tolerance: 2 * time.Second, offset: 2*time.Second + 1, want: "overwrite"},
+		{name: "exact", offset: 1, want: "overwrite"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := newMemFS()
+			fsys.file("/s/f", "a", mtime)
+			fsys.file("/d/f", "a", mtime.Add(tt.offset))
+			cfg := testConfig("/s", "/d")
+			cfg.MtimeTolerance, cfg.DryRun = tt.tolerance, true
+			f := &testFrontend{}
+			if _, err := RunFS(context.Background(), cfg, 1, f, fsys); err != nil {
+				t.Fatalf("RunFS() error = %v, fatal %v", err, f.fatal)
+			}
+			if got := f.sortedActions(); !stringsEqual(got, []string{tt.want + " /d/f"}) {
+				t.Errorf("actions = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
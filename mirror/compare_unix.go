@@ -0,0 +1,60 @@
+//go:build unix
+
+package mirror
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"syscall"
+)
+
+// mmapEqual maps both files into memory and compares them chunk by chunk
+func mmapEqual(a, b string, size int64) (bool, error) {
+	if size == 0 {
+		return true, nil
+	}
+	mapA, err := mmapFile(a, size)
+	if err != nil {
+		return false, err
+	}
+	defer syscall.Munmap(mapA)
+	mapB, err := mmapFile(b, size)
+	if err != nil {
+		return false, err
+	}
+	defer syscall.Munmap(mapB)
+	return equalMapped(mapA, mapB)
+}
+
+// equalMapped compares the mapped files chunk by chunk. Reading beyond the end of a file truncated
+// meanwhile faults, which is returned as error instead of crashing
+func equalMapped(mapA, mapB []byte) (same bool, err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			same, err = false, fmt.Errorf("file changed while compared: %v", r)
+		}
+	}()
+	for off := 0; off < len(mapA); off += compareChunk {
+		end := off + compareChunk
+		if end > len(mapA) {
+			end = len(mapA)
+		}
+		if !bytes.Equal(mapA[off:end], mapB[off:end]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func mmapFile(path string, size int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// the mapping stays valid after closing the file
+	defer f.Close()
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
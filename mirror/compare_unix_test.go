@@ -0,0 +1,47 @@
+//go:build unix
+
+package mirror
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestEqualMappedTruncated(t *testing.T) {
+	tests := []struct {
+		name     string
+		truncate bool
+		wantErr  bool
+	}{
+		{name: "unchanged"},
+		{name: "truncated while mapped", truncate: true, wantErr: true},
+	}
+	page := os.Getpagesize()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			data := string(make([]byte, 4*page))
+			a, b := writeFile(t, dir, "a", data), writeFile(t, dir, "b", data)
+			mapA, err := mmapFile(a, int64(len(data)))
+			if err != nil {
+				t.Skipf("mmap: %v", err)
+			}
+			defer syscall.Munmap(mapA)
+			mapB, err := mmapFile(b, int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer syscall.Munmap(mapB)
+			if tt.truncate {
+				if err := os.Truncate(b, int64(page)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			same, err := equalMapped(mapA, mapB)
+			if (err != nil) != tt.wantErr || !tt.wantErr && !same {
+				t.Errorf("equalMapped() = %v, %v, wantErr %v", same, err, tt.wantErr)
+			}
+		})
+	}
+}
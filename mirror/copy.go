@@ -0,0 +1,416 @@
+package mirror
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/binChris/mirror/config"
+)
+
+var (
+	errUnsupported = errors.New("not supported on this platform")
+	errVerify      = errors.New("verification failed")
+)
+
+// copyOptions control how copyFile transfers the file content
+type copyOptions struct {
+	method        string
+	sparse        bool
+	sparseMinHole int64
+	verify        string
+	inplace       bool
+	mtime         string
+	fixedMtime    time.Time
+	directIO      bool
+	blockSize     int64
+	blockCache    string // dir of the block hashes of -block-sync-cache, empty for none
+	umask         int
+	fsync         bool
+	bufferSize    int
+	// a copy of a source changed during the transfer replaces the destination anyway
+	ignoreChanged bool
+	// nil if not limited, shared by all copies
+	limiter        *rateLimiter
+	mtimeTolerance time.Duration
+	// nil unless the frontend shows the progress of single copies
+	progress func(path string, done, total int64)
+	// files of at least fileParallelMin bytes are copied as fileParallel ranges concurrently
+	fileParallel    int
+	fileParallelMin int64
+}
+
+// directMinSize is the size below which -direct-io copies through the page cache, as they gain nothing from bypassing it
+const directMinSize = 1 << 20
+
+func copyOptionsFrom(cfg config.Config) copyOptions {
+	o := copyOptions{
+		method:          cfg.CopyMethod,
+		sparse:          cfg.Sparse,
+		sparseMinHole:   cfg.SparseMinHole,
+		verify:          cfg.Verify,
+		inplace:         cfg.Inplace,
+		mtime:           cfg.Mtime,
+		fixedMtime:      cfg.MtimeFixed,
+		directIO:        cfg.DirectIO,
+		umask:           cfg.Umask,
+		fileParallel:    cfg.FileParallel,
+		fileParallelMin: cfg.FileParallelMin,
+		fsync:           cfg.Sync,
+		bufferSize:      cfg.BufferSize,
+		mtimeTolerance:  cfg.MtimeTolerance,
+		ignoreChanged:   cfg.IgnoreChanged,
+	}
+	if cfg.RateLimit > 0 {
+		o.limiter = &rateLimiter{rate: float64(cfg.RateLimit)}
+	}
+	if cfg.BlockSync {
+		o.blockSize = cfg.BlockSyncSize
+		o.blockCache = cfg.BlockSyncCache
+		o.inplace = true
+	}
+	// holes would keep the old content of an in-place destination
+	o.sparse = o.sparse && !o.inplace
+	return o
+}
+
+// copyFile copies src to dst on fsys. Unless in place, the copy is written to a temporary file next to dst
+// and renamed when complete, so an interrupted copy never leaves a partial file under the name dst.
+func copyFile(fsys FS, src, dst string, opts copyOptions) (err error) {
+	before, err := fsys.Stat(src)
+	if err != nil {
+		return fmt.Errorf("get file info for '%s': %w", src, err)
+	}
+	final := dst
+	if !opts.inplace {
+		// cerr must not shadow err, which the cleanup below checks
+		f, name, cerr := fsys.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+		if cerr != nil {
+			return fmt.Errorf("Could not create temporary file for '%s': %w", dst, cerr)
+		}
+		f.Close()
+		dst = name
+		defer func() {
+			if err != nil {
+				fsys.Remove(dst)
+			}
+		}()
+	}
+	// with full verification the source is hashed while it is copied, so it is read only once
+	var sum *countingHash
+	if opts.verify == "full" {
+		sum = &countingHash{Hash: sha256.New()}
+	}
+	// wrap returns the reader of the source data passing through user space
+	wrap := func(srcF io.Reader) io.Reader {
+		r := srcF
+		if sum != nil {
+			r = io.TeeReader(r, sum)
+		}
+		if opts.limiter != nil {
+			r = &limitedReader{r: r, l: opts.limiter}
+		}
+		if opts.progress != nil {
+			r = &progressReader{r: r, path: final, total: before.Size(), report: opts.progress}
+		}
+		return r
+	}
+	var blockHashes [][]byte
+	copy := func() error {
+		if fsys != OS {
+			return streamFile(fsys, src, dst, wrap, opts)
+		}
+		// cloning replaces the destination file, so auto keeps it in place. Clones transfer no data to limit or report
+		if opts.method == "clone" || opts.method == "auto" && !opts.inplace && !opts.readsThrough() {
+			err := tryClone(src, dst)
+			if err == nil {
+				return nil
+			}
+			if opts.method == "clone" {
+				return fmt.Errorf("clone '%s': %w", src, err)
+			}
+		}
+		if opts.directIO && opts.blockSize == 0 && !opts.readsThrough() && before.Size() >= directMinSize {
+			err := copyDirect(src, dst, opts.inplace)
+			if !errors.Is(err, errUnsupported) {
+				return err
+			}
+		}
+		if opts.fileParallel > 1 && opts.blockSize == 0 && !opts.readsThrough() && before.Size() >= opts.fileParallelMin {
+			return copyRanges(src, dst, before.Size(), opts.fileParallel, opts.inplace, opts.sparse, opts.sparseMinHole)
+		}
+		srcF, err := os.Open(src)
+		if err != nil {
+			return fmt.Errorf("Could not open '%s' for reading: %w", src, err)
+		}
+		defer srcF.Close()
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if opts.inplace {
+			flags &^= os.O_TRUNC
+		}
+		if opts.blockSize > 0 {
+			flags = os.O_RDWR | os.O_CREATE
+		}
+		dstF, err := os.OpenFile(dst, flags, 0666)
+		if err != nil {
+			return fmt.Errorf("Could not create '%s' for writing: %w", dst, err)
+		}
+		defer dstF.Close()
+		r := wrap(srcF)
+		if opts.blockSize > 0 {
+			var known [][]byte
+			if opts.blockCache != "" {
+				known = loadBlockHashes(opts.blockCache, dst, opts.blockSize)
+			}
+			blockHashes, err = syncBlocks(dstF, r, opts.blockSize, known)
+		} else {
+			err = copyData(dstF, srcF, r, opts)
+		}
+		if err != nil {
+			return fmt.Errorf("error copying file '%s': %w", src, err)
+		}
+		if opts.inplace {
+			// cut off the rest of a longer old content
+			inf, err := srcF.Stat()
+			if err != nil {
+				return fmt.Errorf("get file info for '%s': %w", src, err)
+			}
+			if err := dstF.Truncate(inf.Size()); err != nil {
+				return fmt.Errorf("truncate '%s': %w", dst, err)
+			}
+		}
+		return nil
+	}
+	if err := copy(); err != nil {
+		return err
+	}
+	inf, err := fsys.Stat(src)
+	if err != nil {
+		return fmt.Errorf("get file info for '%s': %w", src, err)
+	}
+	changed := inf.Size() != before.Size() || !inf.ModTime().Equal(before.ModTime())
+	if changed && !opts.ignoreChanged {
+		// the torn copy is removed, in place it keeps the time of writing, so the next run copies it again
+		return fmt.Errorf("%w: '%s'", errFileChanged, src)
+	}
+	// also corrects the mode of an overwritten file
+	perm := inf.Mode().Perm()
+	if opts.umask >= 0 {
+		perm &^= fs.FileMode(opts.umask)
+	}
+	if err := fsys.Chmod(dst, perm); err != nil {
+		return fmt.Errorf("set mode of '%s': %w", dst, err)
+	}
+	mtime := opts.modTime(inf.ModTime())
+	// a copy kept with -ignore-changed is not stamped with the source mtime, so the next run copies it again
+	if !changed {
+		if err := fsys.Chtimes(dst, mtime, mtime); err != nil {
+			return fmt.Errorf("set modification time for '%s': %w", dst, err)
+		}
+	}
+	if blockHashes != nil && opts.blockCache != "" && !changed {
+		if err := saveBlockHashes(opts.blockCache, dst, opts.blockSize, blockHashes); err != nil {
+			return err
+		}
+	}
+	if opts.fsync {
+		if err := syncFile(dst); err != nil {
+			return err
+		}
+	}
+	if !changed {
+		var srcHash []byte
+		// clones and reflinks bypass the hash
+		if sum != nil && sum.n == inf.Size() {
+			srcHash = sum.Sum(nil)
+		}
+		if err := verifyCopy(fsys, src, dst, inf.Size(), mtime, opts, srcHash); err != nil {
+			return err
+		}
+	}
+	if dst != final {
+		if err := fsys.Rename(dst, final); err != nil {
+			return fmt.Errorf("rename '%s': %w", dst, err)
+		}
+	}
+	if opts.fsync {
+		// makes the new dir entry durable
+		if err := syncDir(filepath.Dir(final)); err != nil {
+			return err
+		}
+	}
+	if changed {
+		// the copy is kept for -ignore-changed
+		return fmt.Errorf("%w: '%s'", errFileChanged, src)
+	}
+	return nil
+}
+
+// streamFile copies the content of src to dst on an FS other than the OS, reading through wrap
+func streamFile(fsys FS, src, dst string, wrap func(io.Reader) io.Reader, opts copyOptions) error {
+	srcF, err := fsys.Open(src)
+	if err != nil {
+		return fmt.Errorf("Could not open '%s' for reading: %w", src, err)
+	}
+	defer srcF.Close()
+	dstF, err := fsys.Create(dst)
+	if err != nil {
+		return fmt.Errorf("Could not create '%s' for writing: %w", dst, err)
+	}
+	buf := getBuffer(opts.bufferSize)
+	defer putBuffer(buf)
+	if _, err := io.CopyBuffer(dstF, wrap(srcF), *buf); err != nil {
+		dstF.Close()
+		return fmt.Errorf("error copying file '%s': %w", src, err)
+	}
+	if err := dstF.Close(); err != nil {
+		return fmt.Errorf("error copying file '%s': %w", src, err)
+	}
+	return nil
+}
+
+// fsync flushes an open file or dir to disk, replaced by tests
+var fsync = (*os.File).Sync
+
+// tryReflink and tryClone make copy-on-write copies, replaced by tests
+var (
+	tryReflink = reflink
+	tryClone   = cloneFile
+)
+
+// syncFile flushes the content and metadata of the file at path to disk
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open '%s': %w", path, err)
+	}
+	defer f.Close()
+	if err := fsync(f); err != nil {
+		return fmt.Errorf("sync '%s': %w", path, err)
+	}
+	return nil
+}
+
+// modTime returns the modification time to set on the copy of a file modified at src
+func (o copyOptions) modTime(src time.Time) time.Time {
+	switch o.mtime {
+	case "now":
+		return time.Now()
+	case "fixed", "zero":
+		return o.fixedMtime
+	}
+	return src
+}
+
+// readsThrough reports whether the data must be read through user space to be limited or its progress reported
+func (o copyOptions) readsThrough() bool {
+	return o.limiter != nil || o.progress != nil
+}
+
+// preservesMtime reports whether copies get the modification time of their source, so it can be compared
+func (o copyOptions) preservesMtime() bool {
+	return o.mtime == "" || o.mtime == "preserve"
+}
+
+// sameMtime reports whether the modification times differ by at most the tolerance
+func (o copyOptions) sameMtime(a, b time.Time) bool {
+	d := a.Sub(b)
+	return d >= -o.mtimeTolerance && d <= o.mtimeTolerance
+}
+
+// countingHash is a hash.Hash counting the bytes written to it
+type countingHash struct {
+	hash.Hash
+	n int64
+}
+
+func (h *countingHash) Write(p []byte) (int, error) {
+	n, err := h.Hash.Write(p)
+	h.n += int64(n)
+	return n, err
+}
+
+// verifyCopy checks dst against src: light compares size and the mtime set, full also the content hash.
+// srcHash is the hash of src taken during the copy; if nil, src is hashed again.
+func verifyCopy(fsys FS, src, dst string, size int64, mtime time.Time, opts copyOptions, srcHash []byte) error {
+	level := opts.verify
+	if level != "light" && level != "full" {
+		return nil
+	}
+	dstInf, err := fsys.Stat(dst)
+	if err != nil {
+		return fmt.Errorf("get file info for '%s': %w", dst, err)
+	}
+	if dstInf.Size() != size {
+		return fmt.Errorf("%w: '%s' has %d bytes instead of %d", errVerify, dst, dstInf.Size(), size)
+	}
+	if !opts.sameMtime(dstInf.ModTime(), mtime) {
+		return fmt.Errorf("%w: modification time of '%s' not set", errVerify, dst)
+	}
+	if level == "light" {
+		return nil
+	}
+	if srcHash == nil {
+		if srcHash, err = hashFile(fsys, src); err != nil {
+			return err
+		}
+	}
+	dstHash, err := hashFile(fsys, dst)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(srcHash, dstHash) {
+		return fmt.Errorf("%w: content of '%s' differs from '%s'", errVerify, dst, src)
+	}
+	return nil
+}
+
+// copyData transfers the content of src to dst using the copy method of opts.
+// r reads src; methods copying through user space read from r instead of src.
+func copyData(dst, src *os.File, r io.Reader, opts copyOptions) error {
+	method := opts.method
+	if opts.readsThrough() {
+		// the data must pass through r to be limited or reported
+		method = "read-write"
+	}
+	switch method {
+	case "reflink":
+		return tryReflink(dst, src)
+	case "read-write":
+		if opts.sparse {
+			return copySparse(dst, r, opts.sparseMinHole)
+		}
+		buf := getBuffer(opts.bufferSize)
+		defer putBuffer(buf)
+		// hide ReadFrom/WriteTo so the data passes through user space
+		_, err := io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{r}, *buf)
+		return err
+	case "auto":
+		if tryReflink(dst, src) == nil {
+			return nil
+		}
+		if opts.sparse {
+			return copySparse(dst, r, opts.sparseMinHole)
+		}
+	case "sendfile":
+		if opts.sparse {
+			// the kernel copy writes holes as zeros, so it only gets the data regions
+			if err := copyDataRegions(dst, src); !errors.Is(err, errUnsupported) {
+				return err
+			}
+			return copySparse(dst, r, opts.sparseMinHole)
+		}
+	}
+	buf := getBuffer(opts.bufferSize)
+	defer putBuffer(buf)
+	// lets the kernel copy via copy_file_range/sendfile where available, unless r wraps src
+	_, err := io.CopyBuffer(dst, r, *buf)
+	return err
+}
@@ -0,0 +1,22 @@
+package mirror
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink is only supported on Linux, use cloneFile
+func reflink(dst, src *os.File) error {
+	return errUnsupported
+}
+
+// cloneFile creates dst as a copy-on-write clone of src on APFS
+func cloneFile(src, dst string) error {
+	if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW)
+}
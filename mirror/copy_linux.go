@@ -0,0 +1,17 @@
+package mirror
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink shares the data blocks of src with dst on copy-on-write filesystems like Btrfs or XFS
+func reflink(dst, src *os.File) error {
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+}
+
+// cloneFile is only supported on macOS
+func cloneFile(src, dst string) error {
+	return errUnsupported
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package mirror
+
+import "os"
+
+func reflink(dst, src *os.File) error {
+	return errUnsupported
+}
+
+func cloneFile(src, dst string) error {
+	return errUnsupported
+}
@@ -0,0 +1,248 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCopyFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     func(t *testing.T, dir string) string
+		opts    copyOptions
+		wantErr bool
+	}{
+		{
+			name: "file",
+			src:  func(t *testing.T, dir string) string { return writeFile(t, dir, "a", "content") },
+			opts: copyOptions{method: "read-write", umask: -1},
+		},
+		{
+			name: "verified",
+			src:  func(t *testing.T, dir string) string { return writeFile(t, dir, "a", "content") },
+			opts: copyOptions{method: "read-write", umask: -1, verify: "full"},
+		},
+		{
+			name: "dir fails",
+			src: func(t *testing.T, dir string) string {
+				p := filepath.Join(dir, "d")
+				if err := os.Mkdir(p, 0755); err != nil {
+					t.Fatal(err)
+				}
+				return p
+			},
+			opts:    copyOptions{method: "read-write", umask: -1},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := tt.src(t, t.TempDir())
+			dstDir := t.TempDir()
+			dst := filepath.Join(dstDir, "b")
+			err := copyFile(OS, src, dst, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("copyFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			tmps, _ := filepath.Glob(filepath.Join(dstDir, "*.tmp"))
+			if len(tmps) > 0 {
+				t.Errorf("temporary files left: %v", tmps)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := readFile(t, dst); got != "content" {
+				t.Errorf("copied content = %q", got)
+			}
+		})
+	}
+}
+
+func TestCopyFileFailure(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name          string
+		fsys          func(m *memFS) FS
+		ignoreChanged bool
+		wantErr       error
+		want          string // destination content
+		wantMtime     bool   // destination stamped with the source mtime
+	}{
+		{
+			name:    "write fails",
+			fsys:    func(m *memFS) FS { return &flakyFS{memFS: m, fails: 1, err: syscall.EIO} },
+			wantErr: syscall.EIO,
+			want:    "old",
+		},
+		{
+			name:    "source changed",
+			fsys:    func(m *memFS) FS { return &changingFS{memFS: m, changing: "/s/f"} },
+			wantErr: errFileChanged,
+			want:    "old",
+		},
+		{
+			name:          "source changed, ignored",
+			fsys:          func(m *memFS) FS { return &changingFS{memFS: m, changing: "/s/f"} },
+			ignoreChanged: true,
+			wantErr:       errFileChanged,
+			want:          "new",
+		},
+		{
+			name:      "copied",
+			fsys:      func(m *memFS) FS { return m },
+			want:      "new",
+			wantMtime: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMemFS()
+			m.file("/s/f", "new", mtime)
+			m.file("/d/f", "old", mtime.Add(-time.Hour))
+			err := copyFile(tt.fsys(m), "/s/f", "/d/f", copyOptions{umask: -1, ignoreChanged: tt.ignoreChanged})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("copyFile() error = %v, want %v", err, tt.wantErr)
+			}
+			// no temporary file is left
+			if got := m.tree("/d"); fmt.Sprint(got) != fmt.Sprint(map[string]string{"f": tt.want}) {
+				t.Errorf("destination = %v, want f: %s", got, tt.want)
+			}
+			inf, err := m.Stat("/d/f")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// either the mtime before or after the change
+			if stamped := inf.ModTime().Equal(mtime) || inf.ModTime().Equal(mtime.Add(time.Second)); stamped != tt.wantMtime {
+				t.Errorf("mtime %v, stamped with the source mtime %v", inf.ModTime(), tt.wantMtime)
+			}
+		})
+	}
+}
+
+func TestFsync(t *testing.T) {
+	tests := []struct {
+		name  string
+		fsync bool
+	}{
+		{name: "default"},
+		{name: "fsync", fsync: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var synced []string
+			defer func(f func(*os.File) error) { fsync = f }(fsync)
+			fsync = func(f *os.File) error {
+				synced = append(synced, f.Name())
+				return nil
+			}
+			src := writeFile(t, t.TempDir(), "a", "content")
+			dst := filepath.Join(t.TempDir(), "b")
+			if err := copyFile(OS, src, dst, copyOptions{method: "read-write", umask: -1, fsync: tt.fsync}); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.fsync {
+				if len(synced) > 0 {
+					t.Errorf("synced %v", synced)
+				}
+				return
+			}
+			// the temporary file is synced before it is renamed to dst
+			if len(synced) == 0 || filepath.Dir(synced[0]) != filepath.Dir(dst) {
+				t.Errorf("synced %v, want the copy of %s", synced, dst)
+			}
+		})
+	}
+}
+
+func TestCopyMethodFallback(t *testing.T) {
+	tests := []struct {
+		method  string
+		wantErr bool
+	}{
+		{method: "auto"},
+		{method: "reflink", wantErr: true},
+		{method: "clone", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			// a file system without copy-on-write support
+			defer func(r func(dst, src *os.File) error, c func(src, dst string) error) { tryReflink, tryClone = r, c }(tryReflink, tryClone)
+			tryReflink = func(dst, src *os.File) error { return syscall.EOPNOTSUPP }
+			tryClone = func(src, dst string) error { return errUnsupported }
+			src, dst := t.TempDir(), t.TempDir()
+			content := make([]byte, 1<<20+3)
+			for i := range content {
+				content[i] = byte(i * 7)
+			}
+			if err := os.WriteFile(filepath.Join(src, "a"), content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			cfg := testConfig(src, dst)
+			cfg.CopyMethod = tt.method
+			_, err := Run(context.Background(), cfg, 1, &testFrontend{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got, err := os.ReadFile(filepath.Join(dst, "a"))
+			if tt.wantErr {
+				if !os.IsNotExist(err) {
+					t.Errorf("destination written: %v", err)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(got, content) {
+				t.Errorf("copy differs from the source: %v", err)
+			}
+		})
+	}
+}
+
+// writeFile creates the file name in dir with content and returns its path
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// BenchmarkCopyFileBuffer copies a large file through user space with small and large buffers
+func BenchmarkCopyFileBuffer(b *testing.B) {
+	const size = 64 << 20
+	dir := b.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, make([]byte, size), 0644); err != nil {
+		b.Fatal(err)
+	}
+	for _, bufferSize := range []int{4 << 10, 32 << 10, 256 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("%dk", bufferSize>>10), func(b *testing.B) {
+			opts := copyOptions{method: "read-write", umask: -1, bufferSize: bufferSize}
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				if err := copyFile(OS, src, filepath.Join(dir, "dst"), opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
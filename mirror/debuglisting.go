@@ -0,0 +1,104 @@
+package mirror
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// debugListing records what readDir returned for a source dir and its destination counterpart
+// and the decision taken for every entry
+type debugListing struct {
+	src, dst  map[string]fs.DirEntry
+	decisions map[string]string
+}
+
+// debugListingOut serializes the listings of dirs compared concurrently
+var debugListingOut sync.Mutex
+
+// newDebugListing returns a listing if rel, the source dir relative to the source root, is to be explained
+func newDebugListing(want, rel string, dirs, files map[string]fs.DirEntry) *debugListing {
+	if want == "" || want != "all" && path.Clean(want) != rel {
+		return nil
+	}
+	return &debugListing{src: union(dirs, files), decisions: make(map[string]string)}
+}
+
+func (l *debugListing) destination(dirs, files map[string]fs.DirEntry) {
+	if l != nil {
+		l.dst = union(dirs, files)
+	}
+}
+
+func (l *debugListing) decide(name, decision string) {
+	if l != nil {
+		l.decisions[name] = decision
+	}
+}
+
+// print writes the entries of both sides next to each other, entries without decision were filtered out
+func (l *debugListing) print(src, dst string) {
+	if l == nil {
+		return
+	}
+	names := make([]string, 0, len(l.src)+len(l.dst))
+	for n := range union(l.src, l.dst) {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Listing of %s and %s:\n", src, dst)
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "name\tsource\t\t\tdestination\t\t\tdecision")
+	for _, n := range names {
+		d, ok := l.decisions[n]
+		if !ok {
+			d = "ignored"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", n, describe(l.src[n]), describe(l.dst[n]), d)
+	}
+	w.Flush()
+	debugListingOut.Lock()
+	defer debugListingOut.Unlock()
+	fmt.Print(b.String())
+}
+
+// describe returns type, size and mtime of e as tab separated columns
+func describe(e fs.DirEntry) string {
+	if e == nil {
+		return "-\t\t"
+	}
+	inf, err := e.Info()
+	if err != nil {
+		return fmt.Sprintf("%s\t%s\t", entryType(e), err)
+	}
+	return fmt.Sprintf("%s\t%d\t%s", entryType(e), inf.Size(), inf.ModTime().Format(time.RFC3339))
+}
+
+func entryType(e fs.DirEntry) string {
+	switch {
+	case e.IsDir():
+		return "dir"
+	case e.Type()&fs.ModeSymlink != 0:
+		return "symlink"
+	case e.Type().IsRegular():
+		return "file"
+	}
+	return "other"
+}
+
+func union(a, b map[string]fs.DirEntry) map[string]fs.DirEntry {
+	u := make(map[string]fs.DirEntry, len(a)+len(b))
+	for n, e := range a {
+		u[n] = e
+	}
+	for n, e := range b {
+		u[n] = e
+	}
+	return u
+}
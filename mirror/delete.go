@@ -0,0 +1,73 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync/atomic"
+)
+
+var errCrossMount = errors.New("on a different device than the destination")
+
+// remove deletes the file or dir tree at path, or moves it to the trash dir. With the cross-mount guard enabled,
+// entries on a different device than the destination root are left in place and errCrossMount is returned.
+func (m *mirror) remove(path string, dir bool) error {
+	switch {
+	case m.dryRun:
+		return nil
+	case m.destDev != nil:
+		return m.removeSameDevice(path)
+	}
+	return m.discard(path, dir)
+}
+
+// discard deletes the file or dir tree at path, or moves it to the trash dir
+func (m *mirror) discard(path string, dir bool) error {
+	switch {
+	case m.trash != "":
+		return m.moveToTrash(path)
+	case dir:
+		return m.fs.RemoveAll(path)
+	}
+	return m.fs.Remove(path)
+}
+
+func (m *mirror) removeSameDevice(path string) error {
+	inf, err := m.fs.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if dev, ok := deviceOf(inf); ok && dev != *m.destDev {
+		m.frontend.Progress(fmt.Sprintf("Not deleting %s, it is on a different device", path))
+		atomic.AddUint64(&m.crossMountSkipped, 1)
+		return errCrossMount
+	}
+	if !inf.IsDir() {
+		return m.discard(path, false)
+	}
+	ee, err := m.fs.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	skipped := false
+	for _, e := range ee {
+		err := m.removeSameDevice(filepath.Join(path, e.Name()))
+		if errors.Is(err, errCrossMount) {
+			skipped = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if skipped {
+		return errCrossMount
+	}
+	if m.trash != "" {
+		// the entries are in the trash already, only the empty dir is left
+		return m.trashEmptyDir(path)
+	}
+	return m.fs.Remove(path)
+}
@@ -0,0 +1,92 @@
+package mirror
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/binChris/mirror/config"
+)
+
+func TestDeletePolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		noDelete bool
+		excluded bool // -delete-excluded
+		want     []string
+	}{
+		{name: "force", want: []string{"a", "old.log"}},
+		{name: "no-delete", noDelete: true, want: []string{"a", "old.log", "orphan", "sub/orphan"}},
+		{name: "delete-excluded", excluded: true, want: []string{"a"}},
+		{name: "no-delete wins", noDelete: true, excluded: true, want: []string{"a", "old.log", "orphan", "sub/orphan"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			writeFile(t, src, "a", "a")
+			for _, p := range []string{"orphan", "sub/orphan", "old.log"} {
+				writeFile(t, dst, p, p)
+			}
+			cfg := testConfig(src, dst)
+			cfg.Filters = []config.FilterRule{{Pattern: "*.log"}}
+			cfg.DeleteExcluded = tt.excluded
+			if tt.noDelete {
+				// as set by -no-delete regardless of -force
+				no := 'x'
+				cfg.NoDelete, cfg.DeleteDir, cfg.DeleteFile = true, &no, &no
+			}
+			runTest(t, cfg)
+			if got := treeFiles(t, dst); !stringsEqual(got, tt.want) {
+				t.Errorf("destination %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteAfter(t *testing.T) {
+	tests := []struct {
+		name        string
+		deleteAfter bool
+	}{
+		{name: "during"},
+		{name: "after", deleteAfter: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			var want []string
+			for _, dir := range []string{".", "a", "a/b", "c"} {
+				for _, name := range []string{"f1", "f2", "f3"} {
+					p := filepath.ToSlash(filepath.Join(dir, name))
+					writeFile(t, src, p, p)
+					want = append(want, p)
+				}
+				writeFile(t, dst, filepath.Join(dir, "orphan"), "orphan")
+				writeFile(t, dst, filepath.Join(dir, "old", "f"), "old")
+			}
+			cfg := testConfig(src, dst)
+			cfg.DeleteAfter = tt.deleteAfter
+			_, f := runTest(t, cfg)
+			sort.Strings(want)
+			if got := treeFiles(t, dst); !stringsEqual(got, want) {
+				t.Errorf("destination %v, want %v", got, want)
+			}
+			if !tt.deleteAfter {
+				return
+			}
+			lastCopy, firstDelete := -1, len(f.actions)
+			for i, a := range f.actions {
+				switch {
+				case strings.HasPrefix(a, "copy "):
+					lastCopy = i
+				case strings.HasPrefix(a, "delete-") && i < firstDelete:
+					firstDelete = i
+				}
+			}
+			if firstDelete < lastCopy {
+				t.Errorf("deleted before the copies finished: %v", f.actions)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package mirror
+
+import "io/fs"
+
+// deviceOf is not supported on this platform
+func deviceOf(inf fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// fileID is not supported on this platform
+func fileID(inf fs.FileInfo) (id [2]uint64, nlink uint64, ok bool) {
+	return id, 0, false
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package mirror
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func deviceOf(inf fs.FileInfo) (uint64, bool) {
+	st, ok := inf.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
+
+// fileID returns the device and inode identifying the file and its number of hard links
+func fileID(inf fs.FileInfo) (id [2]uint64, nlink uint64, ok bool) {
+	st, ok := inf.Sys().(*syscall.Stat_t)
+	if !ok {
+		return id, 0, false
+	}
+	return [2]uint64{uint64(st.Dev), uint64(st.Ino)}, uint64(st.Nlink), true
+}
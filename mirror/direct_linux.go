@@ -0,0 +1,79 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// directAlign is the buffer, offset and length alignment O_DIRECT needs on common file systems
+	directAlign = 4096
+	directChunk = 1 << 20
+)
+
+// copyDirect copies src to dst with O_DIRECT, bypassing the page cache.
+// errUnsupported is returned before anything is written if the file systems refuse O_DIRECT.
+func copyDirect(src, dst string, inplace bool) error {
+	srcF, err := os.OpenFile(src, os.O_RDONLY|unix.O_DIRECT, 0)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errUnsupported, err)
+	}
+	defer srcF.Close()
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC | unix.O_DIRECT
+	if inplace {
+		flags &^= os.O_TRUNC
+	}
+	dstF, err := os.OpenFile(dst, flags, 0666)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errUnsupported, err)
+	}
+	defer dstF.Close()
+	buf := alignedBuffer(directChunk)
+	var size int64
+	for {
+		n, err := srcF.Read(buf)
+		if size == 0 && errors.Is(err, unix.EINVAL) {
+			return fmt.Errorf("%w: %s", errUnsupported, err)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		tail := n%directAlign != 0
+		if tail {
+			// the unaligned end of the file is written through the page cache
+			fl, err := unix.FcntlInt(dstF.Fd(), unix.F_GETFL, 0)
+			if err == nil {
+				_, err = unix.FcntlInt(dstF.Fd(), unix.F_SETFL, fl&^unix.O_DIRECT)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := dstF.Write(buf[:n]); err != nil {
+			return err
+		}
+		size += int64(n)
+		if tail {
+			break
+		}
+	}
+	return dstF.Truncate(size)
+}
+
+// alignedBuffer returns a buffer of size bytes starting at a multiple of directAlign
+func alignedBuffer(size int) []byte {
+	b := make([]byte, size+directAlign)
+	off := 0
+	if r := int(uintptr(unsafe.Pointer(&b[0])) % directAlign); r != 0 {
+		off = directAlign - r
+	}
+	return b[off : off+size]
+}
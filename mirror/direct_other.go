@@ -0,0 +1,8 @@
+//go:build !linux
+
+package mirror
+
+// copyDirect is only supported on Linux
+func copyDirect(src, dst string, inplace bool) error {
+	return errUnsupported
+}
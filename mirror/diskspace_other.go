@@ -0,0 +1,7 @@
+//go:build !linux && !darwin
+
+package mirror
+
+func diskSpace(path string) (free, total uint64, err error) {
+	return 0, 0, errUnsupported
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package mirror
+
+// exchange is only supported on Linux, elsewhere the dirs are swapped by two renames
+func exchange(a, b string) error {
+	return errUnsupported
+}
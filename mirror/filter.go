@@ -0,0 +1,150 @@
+package mirror
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/binChris/mirror/config"
+)
+
+// excluded reports whether the entry at rel, the slash separated path relative to the source root, is filtered out.
+// A pattern ending in / only matches dirs. A pattern containing / is matched against the whole relative path,
+// otherwise against the entry name only. The first matching rule decides.
+func excluded(rules []config.FilterRule, rel string, isDir bool) bool {
+	for _, r := range rules {
+		pattern := r.Pattern
+		if strings.HasSuffix(pattern, "/") {
+			if !isDir {
+				continue
+			}
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+		name := rel
+		if strings.Contains(pattern, "/") {
+			pattern = strings.TrimPrefix(pattern, "/")
+		} else {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return !r.Include
+		}
+	}
+	return false
+}
+
+// dropBySize removes the files of the source dir whose size is outside the limits from files,
+// and the same names from dstFiles, so they are neither copied nor deleted
+func (m *mirror) dropBySize(dir string, files, dstFiles map[string]fs.DirEntry) {
+	if m.minSize == 0 && m.maxSize == 0 {
+		return
+	}
+	for name, e := range files {
+		if !m.followLinks && e.Type()&fs.ModeSymlink != 0 {
+			continue
+		}
+		inf, err := m.srcStats.stat(filepath.Join(dir, name))
+		if err != nil {
+			// reported when copied
+			continue
+		}
+		if inf.Size() < m.minSize || m.maxSize > 0 && inf.Size() > m.maxSize {
+			delete(files, name)
+			delete(dstFiles, name)
+		}
+	}
+}
+
+// relPath returns the slash separated path of name in dir relative to root
+func relPath(root, dir, name string) string {
+	rel, err := filepath.Rel(root, filepath.Join(dir, name))
+	if err != nil {
+		return name
+	}
+	return filepath.ToSlash(rel)
+}
+
+// dropExcluded removes the entries of the dir at relDir which are excluded by the filter rules
+func dropExcluded(rules []config.FilterRule, relDir string, entries map[string]fs.DirEntry, isDir bool) {
+	if len(rules) == 0 {
+		return
+	}
+	for name := range entries {
+		if excluded(rules, path.Join(relDir, name), isDir) {
+			delete(entries, name)
+		}
+	}
+}
+
+// readIgnoreFile reads the patterns of the ignore file at p in the source dir at relDir as exclude rules.
+// Blank lines and lines starting with # are skipped, a pattern starting with ! includes. Patterns containing
+// a / are relative to the dir of the ignore file.
+func readIgnoreFile(fsys FS, p, relDir string) ([]config.FilterRule, error) {
+	f, err := fsys.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules []config.FilterRule
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		include := strings.HasPrefix(line, "!")
+		pattern := strings.TrimPrefix(line, "!")
+		if dirPattern := strings.TrimSuffix(pattern, "/"); strings.Contains(dirPattern, "/") && relDir != "." {
+			pattern = path.Join(relDir, strings.TrimPrefix(dirPattern, "/")) + pattern[len(dirPattern):]
+		}
+		rules = append(rules, config.FilterRule{Include: include, Pattern: pattern})
+	}
+	return rules, s.Err()
+}
+
+// ignoreRules tracks the filter rules of the dirs of a walk over the source: the rules of a dir are those of
+// its ignore file, then those of its parent
+type ignoreRules struct {
+	fsys  FS
+	name  string // of the ignore files, empty for none
+	base  []config.FilterRule
+	rules map[string][]config.FilterRule // by slash separated dir relative to the source root
+}
+
+func newIgnoreRules(fsys FS, name string, base []config.FilterRule) *ignoreRules {
+	return &ignoreRules{fsys: fsys, name: name, base: base, rules: make(map[string][]config.FilterRule)}
+}
+
+// enter sets the rules of the source dir at relDir, its parent must have been entered. dir is empty for dirs
+// which only exist in the destination.
+func (r *ignoreRules) enter(dir, relDir string) error {
+	rules := r.base
+	if relDir != "." {
+		rules = r.rules[path.Dir(relDir)]
+	}
+	if dir != "" && r.name != "" {
+		p := filepath.Join(dir, r.name)
+		if inf, err := r.fsys.Stat(p); err == nil && !inf.IsDir() {
+			own, err := readIgnoreFile(r.fsys, p, relDir)
+			if err != nil {
+				return fmt.Errorf("read ignore file '%s': %w", p, err)
+			}
+			rules = append(own, rules...)
+		}
+	}
+	r.rules[relDir] = rules
+	return nil
+}
+
+// of returns the rules of the entered dir at relDir
+func (r *ignoreRules) of(relDir string) []config.FilterRule {
+	return r.rules[relDir]
+}
+
+// excluded reports whether the entry at rel is filtered out by the rules of its dir
+func (r *ignoreRules) excluded(rel string, isDir bool) bool {
+	return excluded(r.rules[path.Dir(rel)], rel, isDir)
+}
@@ -0,0 +1,225 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/binChris/mirror/config"
+)
+
+func TestExcluded(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []config.FilterRule
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{name: "no rules", rel: "a.log"},
+		{name: "excluded", rules: []config.FilterRule{{Pattern: "*.log"}}, rel: "sub/a.log", want: true},
+		{name: "first match wins", rules: []config.FilterRule{{Include: true, Pattern: "a.log"}, {Pattern: "*.log"}}, rel: "a.log"},
+		{name: "later include loses", rules: []config.FilterRule{{Pattern: "*.log"}, {Include: true, Pattern: "a.log"}}, rel: "a.log", want: true},
+		{name: "unmatched included", rules: []config.FilterRule{{Pattern: "*.log"}}, rel: "a.txt"},
+		{name: "dir pattern on file", rules: []config.FilterRule{{Pattern: "tmp/"}}, rel: "tmp"},
+		{name: "dir pattern on dir", rules: []config.FilterRule{{Pattern: "tmp/"}}, rel: "sub/tmp", isDir: true, want: true},
+		{name: "path pattern", rules: []config.FilterRule{{Pattern: "/sub/*.log"}}, rel: "sub/a.log", want: true},
+		{name: "path pattern elsewhere", rules: []config.FilterRule{{Pattern: "/sub/*.log"}}, rel: "other/sub/a.log"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := excluded(tt.rules, tt.rel, tt.isDir); got != tt.want {
+				t.Errorf("excluded(%q) = %v, want %v", tt.rel, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIgnoreFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		ignores map[string]string // ignore files by path
+		want    []string
+	}{
+		{
+			name: "none",
+			want: []string{"a.log", "a.txt", "sub/b.log", "sub/b.txt", "sub/keep.log", "sub/tmp/c.txt"},
+		},
+		{
+			name:    "root",
+			ignores: map[string]string{".mirrorignore": "# logs\n*.log\n"},
+			want:    []string{".mirrorignore", "a.txt", "sub/b.txt", "sub/tmp/c.txt"},
+		},
+		{
+			name:    "nested",
+			ignores: map[string]string{".mirrorignore": "*.log\n", "sub/.mirrorignore": "/tmp/\n!keep.log\n"},
+			want:    []string{".mirrorignore", "a.txt", "sub/.mirrorignore", "sub/b.txt", "sub/keep.log"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			for _, p := range []string{"a.txt", "a.log", "sub/b.txt", "sub/b.log", "sub/keep.log", "sub/tmp/c.txt"} {
+				writeFile(t, src, p, p)
+			}
+			for p, rules := range tt.ignores {
+				writeFile(t, src, p, rules)
+			}
+			cfg := testConfig(src, dst)
+			cfg.IgnoreFile = ".mirrorignore"
+			cfg.List, cfg.ListFormat = true, "path"
+			out := captureStdout(t, func() { runTest(t, cfg) })
+			if got := strings.Fields(out); !stringsEqual(got, listed(tt.want)) {
+				t.Errorf("listed %v, want %v", got, listed(tt.want))
+			}
+			cfg.List = false
+			cfg.Progress = true
+			_, f := runTest(t, cfg)
+			if got := treeFiles(t, dst); !stringsEqual(got, tt.want) {
+				t.Errorf("copied %v, want %v", got, tt.want)
+			}
+			if f.files != len(tt.want) {
+				t.Errorf("counted %d files, want %d", f.files, len(tt.want))
+			}
+			// the ignored files missing in the destination are no mismatches
+			cfg.VerifyExisting = true
+			if _, err := Run(context.Background(), cfg, 2, &testFrontend{}); err != nil {
+				t.Errorf("verify error = %v", err)
+			}
+		})
+	}
+}
+
+// listed returns the entries -list prints for the files: them and their dirs
+func listed(files []string) []string {
+	seen := make(map[string]bool)
+	var entries []string
+	for _, f := range files {
+		for p := f; p != "." && !seen[p]; p = path.Dir(p) {
+			seen[p] = true
+			entries = append(entries, p)
+		}
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// treeFiles returns the slash separated paths of the files below root in order
+func treeFiles(t *testing.T, root string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestFilterRun(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []config.FilterRule
+		want  []string // destination files after the run
+	}{
+		{
+			name: "none",
+			want: []string{"a.tmp", "a.txt", "node_modules/x.js", "sub/b.txt", "sub/node_modules/y.js"},
+		},
+		{
+			name:  "file",
+			rules: []config.FilterRule{{Pattern: "*.tmp"}},
+			want:  []string{"a.txt", "node_modules/x.js", "old.tmp", "sub/b.txt", "sub/node_modules/y.js"},
+		},
+		{
+			name:  "dir",
+			rules: []config.FilterRule{{Pattern: "node_modules/"}},
+			want:  []string{"a.tmp", "a.txt", "node_modules/old.js", "sub/b.txt"},
+		},
+		{
+			name:  "include overrides a later exclude",
+			rules: []config.FilterRule{{Include: true, Pattern: "a.tmp"}, {Pattern: "*.tmp"}},
+			want:  []string{"a.tmp", "a.txt", "node_modules/x.js", "old.tmp", "sub/b.txt", "sub/node_modules/y.js"},
+		},
+		{
+			name:  "exclude overrides a later include",
+			rules: []config.FilterRule{{Pattern: "*.tmp"}, {Include: true, Pattern: "a.tmp"}},
+			want:  []string{"a.txt", "node_modules/x.js", "old.tmp", "sub/b.txt", "sub/node_modules/y.js"},
+		},
+		{
+			name:  "include only by extension",
+			rules: []config.FilterRule{{Include: true, Pattern: "*/"}, {Include: true, Pattern: "*.txt"}, {Pattern: "*"}},
+			want:  []string{"a.txt", "node_modules/old.js", "old.tmp", "sub/b.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			for _, p := range []string{"a.txt", "a.tmp", "node_modules/x.js", "sub/b.txt", "sub/node_modules/y.js"} {
+				writeFile(t, src, p, p)
+			}
+			// excluded destination entries are kept, the others deleted
+			for _, p := range []string{"old.tmp", "old.txt", "node_modules/old.js"} {
+				writeFile(t, dst, p, p)
+			}
+			cfg := testConfig(src, dst)
+			cfg.Filters = tt.rules
+			runTest(t, cfg)
+			if got := treeFiles(t, dst); !stringsEqual(got, tt.want) {
+				t.Errorf("destination %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSizeRange(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name             string
+		minSize, maxSize int64
+		want             map[string]int // destination files and their sizes
+	}{
+		{name: "unlimited", want: map[string]int{"1023": 1023, "1024": 1024, "2048": 2048, "2049": 2049}},
+		{name: "min", minSize: 1 << 10, want: map[string]int{"1023": 3, "1024": 1024, "2048": 2048, "2049": 2049}},
+		{name: "max", maxSize: 2 << 10, want: map[string]int{"1023": 1023, "1024": 1024, "2048": 2048, "2049": 3}},
+		{name: "range", minSize: 1 << 10, maxSize: 2 << 10, want: map[string]int{"1023": 3, "1024": 1024, "2048": 2048, "2049": 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := newMemFS()
+			for _, size := range []int{1023, 1024, 2048, 2049} {
+				fsys.file(fmt.Sprint("/s/", size), strings.Repeat("x", size), mtime)
+			}
+			// skipped source files are left alone in the destination, other files there are deleted
+			fsys.file("/d/1023", "old", mtime)
+			fsys.file("/d/2049", "old", mtime)
+			fsys.file("/d/orphan", "old", mtime)
+			cfg := testConfig("/s", "/d")
+			cfg.MinSize, cfg.MaxSize = tt.minSize, tt.maxSize
+			f := &testFrontend{}
+			if _, err := RunFS(context.Background(), cfg, 1, f, fsys); err != nil {
+				t.Fatalf("RunFS() error = %v, fatal %v", err, f.fatal)
+			}
+			got := make(map[string]int)
+			for name, content := range fsys.tree("/d") {
+				got[name] = len(content)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("destination %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
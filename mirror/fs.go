@@ -0,0 +1,123 @@
+package mirror
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// FS is the file system the mirror pass scans, compares and changes. On the OS, copies use the fast paths
+// of the platform, other file systems get the data streamed from Open to CreateTemp. The device checks
+// of -no-cross-mount-delete and -reserve, -mmap-compare and -fsync use the OS directly.
+type FS interface {
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	Open(name string) (fs.File, error)
+	Create(name string) (io.WriteCloser, error)
+	// CreateTemp creates a new file in dir like os.CreateTemp and returns it with its path
+	CreateTemp(dir, pattern string) (io.WriteCloser, string, error)
+	Mkdir(name string, perm fs.FileMode) error
+	Remove(name string) error
+	RemoveAll(name string) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Chmod(name string, mode fs.FileMode) error
+	Lchown(name string, uid, gid int) error
+	Rename(oldname, newname string) error
+	Readlink(name string) (string, error)
+	Symlink(oldname, newname string) error
+	Link(oldname, newname string) error
+}
+
+// XattrFS is an FS with extended attributes, which -align-metadata aligns
+type XattrFS interface {
+	FS
+	Listxattr(name string) ([]string, error)
+	Getxattr(name, attr string) ([]byte, error)
+	Setxattr(name, attr string, value []byte) error
+	Removexattr(name, attr string) error
+}
+
+// ChecksumFS is an FS computing the checksums of its files itself, e.g. on a remote server or from stored
+// metadata, so files are compared without reading their data. Others are hashed by reading them.
+type ChecksumFS interface {
+	FS
+	// Checksum returns the checksum of the file at name by the algorithm algo, e.g. sha256
+	Checksum(name, algo string) ([]byte, error)
+}
+
+// OS is the FS of the operating system
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (osFS) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (osFS) Lstat(name string) (fs.FileInfo, error)     { return os.Lstat(name) }
+func (osFS) Open(name string) (fs.File, error)          { return os.Open(name) }
+func (osFS) Create(name string) (io.WriteCloser, error) { return os.Create(name) }
+func (osFS) Mkdir(name string, perm fs.FileMode) error  { return os.Mkdir(name, perm) }
+func (osFS) Remove(name string) error                   { return os.Remove(name) }
+func (osFS) RemoveAll(name string) error                { return os.RemoveAll(name) }
+func (osFS) Chmod(name string, mode fs.FileMode) error  { return os.Chmod(name, mode) }
+func (osFS) Lchown(name string, uid, gid int) error     { return os.Lchown(name, uid, gid) }
+func (osFS) Rename(oldname, newname string) error       { return os.Rename(oldname, newname) }
+func (osFS) Readlink(name string) (string, error)       { return os.Readlink(name) }
+func (osFS) Symlink(oldname, newname string) error      { return os.Symlink(oldname, newname) }
+func (osFS) Link(oldname, newname string) error         { return os.Link(oldname, newname) }
+func (osFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (osFS) CreateTemp(dir, pattern string) (io.WriteCloser, string, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	return f, f.Name(), nil
+}
+
+// walkDir walks the tree at root on fsys like filepath.WalkDir, reading the dirs in lexical order
+func walkDir(fsys FS, root string, fn fs.WalkDirFunc) error {
+	inf, err := fsys.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walk(fsys, root, fs.FileInfoToDirEntry(inf), fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walk(fsys FS, path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == filepath.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+	ee, err := fsys.ReadDir(path)
+	if err != nil {
+		if err = fn(path, d, err); err != nil {
+			if err == filepath.SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+	sort.Slice(ee, func(i, j int) bool { return ee[i].Name() < ee[j].Name() })
+	for _, e := range ee {
+		if err := walk(fsys, filepath.Join(path, e.Name()), e, fn); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,676 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// memFS is an FS held in memory. Paths are absolute, symlinks are only followed as the last path element
+type memFS struct {
+	m     sync.Mutex
+	nodes map[string]*memNode
+	temps int
+}
+
+type memNode struct {
+	mode     fs.FileMode
+	data     []byte
+	mtime    time.Time
+	target   string
+	uid, gid int
+}
+
+func newMemFS() *memFS {
+	return &memFS{nodes: map[string]*memNode{"/": {mode: fs.ModeDir | 0755, mtime: time.Now()}}}
+}
+
+// file creates the file at path with content and mtime, and any missing parent dirs
+func (m *memFS) file(path, content string, mtime time.Time) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	for d := filepath.Dir(path); m.nodes[d] == nil; d = filepath.Dir(d) {
+		m.nodes[d] = &memNode{mode: fs.ModeDir | 0755, mtime: mtime}
+	}
+	m.nodes[path] = &memNode{mode: 0644, data: []byte(content), mtime: mtime}
+}
+
+// tree returns the files below dir as relative path: content, symlinks as path -> target and dirs as path/
+func (m *memFS) tree(dir string) map[string]string {
+	m.m.Lock()
+	defer m.m.Unlock()
+	t := make(map[string]string)
+	for p, n := range m.nodes {
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		switch {
+		case n.mode.IsDir():
+			t[rel+"/"] = ""
+		case n.mode&fs.ModeSymlink != 0:
+			t[rel] = "-> " + n.target
+		default:
+			t[rel] = string(n.data)
+		}
+	}
+	return t
+}
+
+func pathErr(op, path string, err error) error {
+	return &fs.PathError{Op: op, Path: path, Err: err}
+}
+
+// node returns the node at path, following a symlink at path if follow is set. Must be called with m.m locked
+func (m *memFS) node(op, path string, follow bool) (string, *memNode, error) {
+	path = filepath.Clean(path)
+	for i := 0; i < 40; i++ {
+		n, ok := m.nodes[path]
+		if !ok {
+			return "", nil, pathErr(op, path, fs.ErrNotExist)
+		}
+		if !follow || n.mode&fs.ModeSymlink == 0 {
+			return path, n, nil
+		}
+		if filepath.IsAbs(n.target) {
+			path = filepath.Clean(n.target)
+		} else {
+			path = filepath.Join(filepath.Dir(path), n.target)
+		}
+	}
+	return "", nil, pathErr(op, path, syscall.ELOOP)
+}
+
+// parent checks that the parent dir of path exists. Must be called with m.m locked
+func (m *memFS) parent(op, path string) error {
+	if n, ok := m.nodes[filepath.Dir(path)]; !ok || !n.mode.IsDir() {
+		return pathErr(op, path, fs.ErrNotExist)
+	}
+	return nil
+}
+
+// children returns the paths directly below dir. Must be called with m.m locked
+func (m *memFS) children(dir string) []string {
+	var c []string
+	for p := range m.nodes {
+		if p != dir && filepath.Dir(p) == dir {
+			c = append(c, p)
+		}
+	}
+	sort.Strings(c)
+	return c
+}
+
+type memInfo struct {
+	name  string
+	mode  fs.FileMode
+	size  int64
+	mtime time.Time
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return i.mode }
+func (i memInfo) ModTime() time.Time { return i.mtime }
+func (i memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memInfo) Sys() any           { return nil }
+
+func infoOf(path string, n *memNode) memInfo {
+	return memInfo{name: filepath.Base(path), mode: n.mode, size: int64(len(n.data)), mtime: n.mtime}
+}
+
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	p, n, err := m.node("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mode.IsDir() {
+		return nil, pathErr("readdir", name, syscall.ENOTDIR)
+	}
+	var ee []fs.DirEntry
+	for _, c := range m.children(p) {
+		ee = append(ee, fs.FileInfoToDirEntry(infoOf(c, m.nodes[c])))
+	}
+	return ee, nil
+}
+
+func (m *memFS) Stat(name string) (fs.FileInfo, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	_, n, err := m.node("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return infoOf(name, n), nil
+}
+
+func (m *memFS) Lstat(name string) (fs.FileInfo, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	_, n, err := m.node("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return infoOf(name, n), nil
+}
+
+type memFile struct {
+	*bytes.Reader
+	info memInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.info.IsDir() {
+		return 0, pathErr("read", f.info.name, syscall.EISDIR)
+	}
+	return f.Reader.Read(p)
+}
+
+func (m *memFS) Open(name string) (fs.File, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	_, n, err := m.node("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return &memFile{Reader: bytes.NewReader(n.data), info: infoOf(name, n)}, nil
+}
+
+// memWriter stores the data written to the file at path on Close
+type memWriter struct {
+	bytes.Buffer
+	fs   *memFS
+	path string
+}
+
+func (w *memWriter) Close() error {
+	w.fs.m.Lock()
+	defer w.fs.m.Unlock()
+	_, n, err := w.fs.node("close", w.path, true)
+	if err != nil {
+		return err
+	}
+	n.data = w.Bytes()
+	n.mtime = time.Now()
+	return nil
+}
+
+func (m *memFS) Create(name string) (io.WriteCloser, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if err := m.parent("create", name); err != nil {
+		return nil, err
+	}
+	p, n, err := m.node("create", name, true)
+	if errors.Is(err, fs.ErrNotExist) {
+		p, n = filepath.Clean(name), &memNode{mode: 0666}
+		m.nodes[p] = n
+	} else if err != nil {
+		return nil, err
+	}
+	n.data, n.mtime = nil, time.Now()
+	return &memWriter{fs: m, path: p}, nil
+}
+
+func (m *memFS) CreateTemp(dir, pattern string) (io.WriteCloser, string, error) {
+	m.m.Lock()
+	m.temps++
+	name := filepath.Join(dir, strings.Replace(pattern, "*", fmt.Sprint(m.temps), 1))
+	m.m.Unlock()
+	w, err := m.Create(name)
+	return w, name, err
+}
+
+func (m *memFS) Mkdir(name string, perm fs.FileMode) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	name = filepath.Clean(name)
+	if _, ok := m.nodes[name]; ok {
+		return pathErr("mkdir", name, fs.ErrExist)
+	}
+	if err := m.parent("mkdir", name); err != nil {
+		return err
+	}
+	m.nodes[name] = &memNode{mode: fs.ModeDir | perm, mtime: time.Now()}
+	return nil
+}
+
+func (m *memFS) Remove(name string) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	p, _, err := m.node("remove", name, false)
+	if err != nil {
+		return err
+	}
+	if len(m.children(p)) > 0 {
+		return pathErr("remove", name, syscall.ENOTEMPTY)
+	}
+	delete(m.nodes, p)
+	return nil
+}
+
+func (m *memFS) RemoveAll(name string) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	name = filepath.Clean(name)
+	for p := range m.nodes {
+		if p == name || strings.HasPrefix(p, name+"/") {
+			delete(m.nodes, p)
+		}
+	}
+	return nil
+}
+
+func (m *memFS) Chtimes(name string, atime, mtime time.Time) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	_, n, err := m.node("chtimes", name, true)
+	if err != nil {
+		return err
+	}
+	n.mtime = mtime
+	return nil
+}
+
+func (m *memFS) Chmod(name string, mode fs.FileMode) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	_, n, err := m.node("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	n.mode = n.mode.Type() | mode.Perm()
+	return nil
+}
+
+func (m *memFS) Lchown(name string, uid, gid int) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	_, n, err := m.node("lchown", name, false)
+	if err != nil {
+		return err
+	}
+	n.uid, n.gid = uid, gid
+	return nil
+}
+
+func (m *memFS) Rename(oldname, newname string) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	oldname, newname = filepath.Clean(oldname), filepath.Clean(newname)
+	if _, _, err := m.node("rename", oldname, false); err != nil {
+		return err
+	}
+	if err := m.parent("rename", newname); err != nil {
+		return err
+	}
+	for p, n := range m.nodes {
+		if p == oldname || strings.HasPrefix(p, oldname+"/") {
+			delete(m.nodes, p)
+			m.nodes[newname+strings.TrimPrefix(p, oldname)] = n
+		}
+	}
+	return nil
+}
+
+func (m *memFS) Readlink(name string) (string, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	_, n, err := m.node("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if n.mode&fs.ModeSymlink == 0 {
+		return "", pathErr("readlink", name, syscall.EINVAL)
+	}
+	return n.target, nil
+}
+
+func (m *memFS) Symlink(oldname, newname string) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	newname = filepath.Clean(newname)
+	if _, ok := m.nodes[newname]; ok {
+		return pathErr("symlink", newname, fs.ErrExist)
+	}
+	if err := m.parent("symlink", newname); err != nil {
+		return err
+	}
+	m.nodes[newname] = &memNode{mode: fs.ModeSymlink | 0777, target: oldname, mtime: time.Now()}
+	return nil
+}
+
+func (m *memFS) Link(oldname, newname string) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	_, n, err := m.node("link", oldname, false)
+	if err != nil {
+		return err
+	}
+	newname = filepath.Clean(newname)
+	if _, ok := m.nodes[newname]; ok {
+		return pathErr("link", newname, fs.ErrExist)
+	}
+	m.nodes[newname] = n
+	return nil
+}
+
+func TestRunFS(t *testing.T) {
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	newer := old.Add(time.Hour)
+	tests := []struct {
+		name        string
+		src, dst    map[string]string
+		links       map[string]string
+		dstMtime    time.Time
+		want        map[string]string
+		wantActions []string
+	}{
+		{
+			name:        "copy into empty destination",
+			src:         map[string]string{"a": "a", "sub/b": "b"},
+			want:        map[string]string{"a": "a", "sub/": "", "sub/b": "b"},
+			wantActions: []string{"copy /d/a", "copy /d/sub/b", "mkdir /d/sub"},
+		},
+		{
+			name:        "identical",
+			src:         map[string]string{"a": "a"},
+			dst:         map[string]string{"a": "a"},
+			dstMtime:    old,
+			want:        map[string]string{"a": "a"},
+			wantActions: []string{"identical /d/a"},
+		},
+		{
+			name:        "overwrite changed",
+			src:         map[string]string{"a": "new"},
+			dst:         map[string]string{"a": "old"},
+			dstMtime:    newer,
+			want:        map[string]string{"a": "new"},
+			wantActions: []string{"overwrite /d/a"},
+		},
+		{
+			name:        "delete orphans",
+			src:         map[string]string{"a": "a"},
+			dst:         map[string]string{"a": "a", "b": "b", "old/c": "c"},
+			dstMtime:    old,
+			want:        map[string]string{"a": "a"},
+			wantActions: []string{"delete-dir /d/old", "delete-file /d/b", "identical /d/a"},
+		},
+		{
+			name:        "recreate symlink",
+			src:         map[string]string{"a": "a"},
+			links:       map[string]string{"l": "a"},
+			dst:         map[string]string{"a": "a"},
+			dstMtime:    old,
+			want:        map[string]string{"a": "a", "l": "-> a"},
+			wantActions: []string{"identical /d/a", "symlink /d/l"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := newMemFS()
+			for _, d := range []string{"/s", "/d"} {
+				if err := fsys.Mkdir(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for p, c := range tt.src {
+				fsys.file(filepath.Join("/s", p), c, old)
+			}
+			for p, target := range tt.links {
+				if err := fsys.Symlink(target, filepath.Join("/s", p)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for p, c := range tt.dst {
+				fsys.file(filepath.Join("/d", p), c, tt.dstMtime)
+			}
+			f := &testFrontend{}
+			if _, err := RunFS(context.Background(), testConfig("/s", "/d"), 2, f, fsys); err != nil {
+				t.Fatalf("RunFS() error = %v, fatal %v", err, f.fatal)
+			}
+			if got := fsys.tree("/d"); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("destination = %v, want %v", got, tt.want)
+			}
+			if got := f.sortedActions(); !stringsEqual(got, tt.wantActions) {
+				t.Errorf("actions = %v, want %v", got, tt.wantActions)
+			}
+		})
+	}
+}
+
+// checksumFS is a memFS with stored checksums, which counts the files opened for reading
+type checksumFS struct {
+	*memFS
+	sums  map[string][]byte
+	opens int
+}
+
+func (c *checksumFS) Checksum(name, algo string) ([]byte, error) {
+	if algo != "sha256" {
+		return nil, fmt.Errorf("unsupported algorithm %s", algo)
+	}
+	if sum, ok := c.sums[name]; ok {
+		return sum, nil
+	}
+	return nil, pathErr("checksum", name, fs.ErrNotExist)
+}
+
+func (c *checksumFS) Open(name string) (fs.File, error) {
+	c.m.Lock()
+	c.opens++
+	c.m.Unlock()
+	return c.memFS.Open(name)
+}
+
+func TestChecksumFS(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name       string
+		dstSum     string
+		want       string
+		wantAction string
+	}{
+		{name: "same checksum", dstSum: "a", want: "b", wantAction: "identical /d/f"},
+		{name: "other checksum", dstSum: "b", want: "a", wantAction: "overwrite /d/f"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the contents differ from the checksums, so a comparison reading them is noticed
+			fsys := &checksumFS{memFS: newMemFS(), sums: map[string][]byte{"/s/f": []byte("a"), "/d/f": []byte(tt.dstSum)}}
+			fsys.file("/s/f", "a", mtime)
+			fsys.file("/d/f", "b", mtime.Add(time.Hour))
+			cfg := testConfig("/s", "/d")
+			cfg.Checksum = true
+			f := &testFrontend{}
+			if _, err := RunFS(context.Background(), cfg, 1, f, fsys); err != nil {
+				t.Fatalf("RunFS() error = %v, fatal %v", err, f.fatal)
+			}
+			if got := fsys.tree("/d")["f"]; got != tt.want {
+				t.Errorf("destination f = %q, want %q", got, tt.want)
+			}
+			if got := f.sortedActions(); !stringsEqual(got, []string{tt.wantAction}) {
+				t.Errorf("actions = %v, want %s", got, tt.wantAction)
+			}
+			if opens := fsys.opens; tt.wantAction == "identical /d/f" && opens > 0 {
+				t.Errorf("%d files read for the comparison", opens)
+			}
+		})
+	}
+}
+
+// countingFS is a memFS counting the files opened for reading
+type countingFS struct {
+	*memFS
+	opens map[string]int
+}
+
+func newCountingFS() *countingFS {
+	return &countingFS{memFS: newMemFS(), opens: make(map[string]int)}
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	c.m.Lock()
+	c.opens[name]++
+	c.m.Unlock()
+	return c.memFS.Open(name)
+}
+
+// deniedFS is a memFS refusing to open the files in denied for reading
+type deniedFS struct {
+	*memFS
+	denied map[string]bool
+}
+
+func (d *deniedFS) Open(name string) (fs.File, error) {
+	if d.denied[name] {
+		return nil, pathErr("open", name, fs.ErrPermission)
+	}
+	return d.memFS.Open(name)
+}
+
+// readOnlyFS is a memFS on which no entries can be created in the dir ro
+type readOnlyFS struct {
+	*memFS
+	ro string
+}
+
+func (r *readOnlyFS) Mkdir(name string, perm fs.FileMode) error {
+	if filepath.Dir(name) == r.ro {
+		return pathErr("mkdir", name, fs.ErrPermission)
+	}
+	return r.memFS.Mkdir(name, perm)
+}
+
+func (r *readOnlyFS) Create(name string) (io.WriteCloser, error) {
+	if filepath.Dir(name) == r.ro {
+		return nil, pathErr("create", name, fs.ErrPermission)
+	}
+	return r.memFS.Create(name)
+}
+
+func (r *readOnlyFS) CreateTemp(dir, pattern string) (io.WriteCloser, string, error) {
+	if dir == r.ro {
+		return nil, "", pathErr("createtemp", filepath.Join(dir, pattern), fs.ErrPermission)
+	}
+	return r.memFS.CreateTemp(dir, pattern)
+}
+
+// growingFS is a memFS on which the file growing is appended to, as by a writer, whenever it is stat'ed
+type growingFS struct {
+	*memFS
+	growing string
+}
+
+func (g *growingFS) Stat(name string) (fs.FileInfo, error) {
+	if name == g.growing {
+		g.m.Lock()
+		if n := g.nodes[name]; n != nil {
+			n.data, n.mtime = append(n.data, "more"...), time.Now()
+		}
+		g.m.Unlock()
+	}
+	return g.memFS.Stat(name)
+}
+
+// flakyFS is a memFS whose first fails files created for writing fail with err
+type flakyFS struct {
+	*memFS
+	fails    int
+	err      error
+	attempts int
+}
+
+// failingWriter fails every write with err
+type failingWriter struct {
+	io.WriteCloser
+	err error
+}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+func (f *flakyFS) writer(w io.WriteCloser) io.WriteCloser {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.attempts++
+	if f.attempts <= f.fails {
+		return failingWriter{w, f.err}
+	}
+	return w
+}
+
+func (f *flakyFS) Create(name string) (io.WriteCloser, error) {
+	w, err := f.memFS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return f.writer(w), nil
+}
+
+// changingFS is a memFS on which the file changing is rewritten in place, same size and a second later,
+// as soon as it is opened for reading
+type changingFS struct {
+	*memFS
+	changing string
+}
+
+func (c *changingFS) Open(name string) (fs.File, error) {
+	f, err := c.memFS.Open(name)
+	if err == nil && name == c.changing {
+		c.m.Lock()
+		n := c.nodes[name]
+		n.data, n.mtime = bytes.ToUpper(n.data), n.mtime.Add(time.Second)
+		c.m.Unlock()
+	}
+	return f, err
+}
+
+// blockingFS is a memFS whose files created for writing block until release is closed. copying is closed
+// when the first one blocks, the dirs below waitDir are only read once it is, or after a timeout
+type blockingFS struct {
+	*memFS
+	waitDir  string
+	once     sync.Once
+	copying  chan struct{}
+	release  chan struct{}
+	timedOut atomic.Bool
+}
+
+func newBlockingFS(waitDir string) *blockingFS {
+	return &blockingFS{memFS: newMemFS(), waitDir: waitDir, copying: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (b *blockingFS) Create(name string) (io.WriteCloser, error) {
+	b.once.Do(func() { close(b.copying) })
+	<-b.release
+	return b.memFS.Create(name)
+}
+
+func (b *blockingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if filepath.Dir(name) == b.waitDir {
+		select {
+		case <-b.copying:
+		case <-time.After(5 * time.Second):
+			b.timedOut.Store(true)
+		}
+	}
+	return b.memFS.ReadDir(name)
+}
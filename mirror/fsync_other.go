@@ -0,0 +1,8 @@
+//go:build !unix
+
+package mirror
+
+// syncDir is a no-op on platforms where a dir cannot be opened to sync it
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package mirror
+
+import (
+	"fmt"
+	"os"
+)
+
+// syncDir flushes the entries of dir to disk
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open '%s': %w", dir, err)
+	}
+	defer f.Close()
+	if err := fsync(f); err != nil {
+		return fmt.Errorf("sync '%s': %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package mirror
+
+import (
+	"fmt"
+	"sync"
+)
+
+// hardlinks tracks source files sharing an inode, which are copied as independent files
+type hardlinks struct {
+	m     sync.Mutex
+	paths map[[2]uint64][]string
+	size  map[[2]uint64]int64
+}
+
+func newHardlinks() *hardlinks {
+	return &hardlinks{
+		paths: make(map[[2]uint64][]string),
+		size:  make(map[[2]uint64]int64),
+	}
+}
+
+// checkHardlink records path if it has more than one hard link and reports it if another path of the same inode was seen
+func (m *mirror) checkHardlink(path string) {
+	inf, err := m.srcStats.stat(path)
+	if err != nil {
+		m.fail(fmt.Sprintf("Cannot get file info for '%s': %s", path, err))
+		return
+	}
+	id, nlink, ok := fileID(inf)
+	if !ok || nlink < 2 || !inf.Mode().IsRegular() {
+		return
+	}
+	h := m.hardlinks
+	h.m.Lock()
+	defer h.m.Unlock()
+	if paths := h.paths[id]; len(paths) > 0 {
+		m.frontend.Progress(fmt.Sprintf("Warning: %s is a hard link of %s and will be copied separately", path, paths[0]))
+	}
+	h.paths[id] = append(h.paths[id], path)
+	h.size[id] = inf.Size()
+}
+
+// extra returns the number of inodes seen by more than one path and the bytes used by their additional copies
+func (h *hardlinks) extra() (inodes int, bytes int64) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	for id, paths := range h.paths {
+		if len(paths) > 1 {
+			inodes++
+			bytes += int64(len(paths)-1) * h.size[id]
+		}
+	}
+	return inodes, bytes
+}
@@ -0,0 +1,41 @@
+package mirror
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter limits the bytes per second passed through all its limitedReaders together
+type rateLimiter struct {
+	m    sync.Mutex
+	rate float64
+	// when the bytes granted so far have been paid off at rate
+	next time.Time
+}
+
+// wait blocks until n more bytes can be passed without exceeding the rate
+func (l *rateLimiter) wait(n int) {
+	l.m.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	d := l.next.Sub(now)
+	l.m.Unlock()
+	time.Sleep(d)
+}
+
+type limitedReader struct {
+	r io.Reader
+	l *rateLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.l.wait(n)
+	}
+	return n, err
+}
@@ -0,0 +1,41 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	const limit = 200 << 10
+	tests := []struct {
+		name     string
+		files    int
+		parallel int
+	}{
+		{name: "one file", files: 1, parallel: 1},
+		{name: "parallel copies share the limit", files: 4, parallel: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			size := 100 << 10 / tt.files
+			for i := 0; i < tt.files; i++ {
+				writeFile(t, src, fmt.Sprint(i), strings.Repeat("x", size))
+			}
+			cfg := testConfig(src, dst)
+			cfg.RateLimit = limit
+			start := time.Now()
+			f := &testFrontend{}
+			if _, err := Run(context.Background(), cfg, tt.parallel, f); err != nil {
+				t.Fatalf("Run() error = %v, fatal %v", err, f.fatal)
+			}
+			want := time.Duration(float64(tt.files*size) / limit * float64(time.Second))
+			if elapsed := time.Since(start); elapsed < want {
+				t.Errorf("copied %d bytes in %v, want at least %v", tt.files*size, elapsed, want)
+			}
+		})
+	}
+}
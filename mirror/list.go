@@ -0,0 +1,158 @@
+package mirror
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/binChris/mirror/config"
+)
+
+type listEntry struct {
+	status string
+	path   string
+	inf    fs.FileInfo
+}
+
+// list prints the status of every entry in source and destination without changing anything
+func list(cfg config.Config, frontend Frontend) error {
+	m := mirror{
+		fs:          OS,
+		frontend:    frontend,
+		srcStats:    newStatCache(OS),
+		filters:     cfg.Filters,
+		copyOpts:    copyOptionsFrom(cfg),
+		checksum:    cfg.Checksum,
+		followLinks: cfg.FollowSymlinks,
+		mmapCompare: cfg.MmapCompare,
+		mmapMin:     cfg.MmapMinSize,
+	}
+	var entries []listEntry
+	if err := m.listDir(cfg.Source, cfg.Destination, "", newIgnoreRules(OS, cfg.IgnoreFile, m.filters), &entries); err != nil {
+		return err
+	}
+	if m.failed.Load() {
+		return m.err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+	columns := strings.Split(cfg.ListFormat, ",")
+	if cfg.ListStyle == "aligned" {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		defer w.Flush()
+		for _, e := range entries {
+			fmt.Fprintln(w, strings.Join(e.fields(columns), "\t"))
+		}
+		return nil
+	}
+	for _, e := range entries {
+		fmt.Println(strings.Join(e.fields(columns), "\t"))
+	}
+	return nil
+}
+
+// listDir adds the entries of the source and destination dir to entries, filtered by the rules of ignore.
+// Either dir may be empty if it does not exist
+func (m *mirror) listDir(src, dst, rel string, ignore *ignoreRules, entries *[]listEntry) error {
+	var sDirs, sFiles, dDirs, dFiles map[string]fs.DirEntry
+	var err error
+	if src != "" {
+		if sDirs, sFiles, err = readDir(OS, src, false, m.followLinks); err != nil {
+			return fmt.Errorf("read directory '%s': %w", src, err)
+		}
+		if m.followLinks {
+			dropLinkCycles(src, sDirs)
+		}
+	}
+	if dst != "" {
+		if dDirs, dFiles, err = readDir(OS, dst, false, false); err != nil {
+			return fmt.Errorf("read directory '%s': %w", dst, err)
+		}
+	}
+	relDir := filepath.ToSlash(filepath.Join(".", rel))
+	if err := ignore.enter(src, relDir); err != nil {
+		return err
+	}
+	for _, entries := range []map[string]fs.DirEntry{sDirs, dDirs} {
+		dropExcluded(ignore.of(relDir), relDir, entries, true)
+	}
+	for _, entries := range []map[string]fs.DirEntry{sFiles, dFiles} {
+		dropExcluded(ignore.of(relDir), relDir, entries, false)
+	}
+	add := func(status, name string, e fs.DirEntry) error {
+		inf, err := e.Info()
+		if err != nil {
+			return fmt.Errorf("get file info for '%s': %w", name, err)
+		}
+		*entries = append(*entries, listEntry{status: status, path: filepath.Join(rel, name), inf: inf})
+		return nil
+	}
+	for name, e := range sFiles {
+		status := "new"
+		if d, exInDst := dFiles[name]; exInDst {
+			status = "identical"
+			if m.entriesDiffer(filepath.Join(src, name), filepath.Join(dst, name), e, d) {
+				status = "changed"
+			}
+		}
+		if err := add(status, name, e); err != nil {
+			return err
+		}
+	}
+	for name, e := range dFiles {
+		if _, exInSrc := sFiles[name]; !exInSrc {
+			if err := add("orphan", name, e); err != nil {
+				return err
+			}
+		}
+	}
+	for name, e := range sDirs {
+		status, subDst := "new", ""
+		if _, exInDst := dDirs[name]; exInDst {
+			status, subDst = "identical", filepath.Join(dst, name)
+		}
+		if err := add(status, name, e); err != nil {
+			return err
+		}
+		if err := m.listDir(filepath.Join(src, name), subDst, filepath.Join(rel, name), ignore, entries); err != nil {
+			return err
+		}
+	}
+	for name, e := range dDirs {
+		if _, exInSrc := sDirs[name]; !exInSrc {
+			if err := add("orphan", name, e); err != nil {
+				return err
+			}
+			if err := m.listDir("", filepath.Join(dst, name), filepath.Join(rel, name), ignore, entries); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e listEntry) fields(columns []string) []string {
+	fields := make([]string, len(columns))
+	for i, c := range columns {
+		switch c {
+		case "status":
+			fields[i] = e.status
+		case "type":
+			fields[i] = "file"
+			if e.inf.IsDir() {
+				fields[i] = "dir"
+			}
+		case "size":
+			fields[i] = fmt.Sprint(e.inf.Size())
+		case "mtime":
+			fields[i] = e.inf.ModTime().Format(time.RFC3339)
+		case "path":
+			fields[i] = e.path
+		}
+	}
+	return fields
+}
@@ -0,0 +1,101 @@
+package mirror
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+type loggingFrontend struct {
+	Frontend
+	w io.Writer
+}
+
+// WithLog returns a frontend writing every progress message to w, unthrottled and with a timestamp,
+// before passing it on to f
+func WithLog(f Frontend, w io.Writer) Frontend {
+	return &loggingFrontend{Frontend: f, w: w}
+}
+
+func (l *loggingFrontend) Unwrap() Frontend {
+	return l.Frontend
+}
+
+func (l *loggingFrontend) Progress(msg string) {
+	l.log(msg)
+	l.Frontend.Progress(msg)
+}
+
+func (l *loggingFrontend) Fatal(msg string) {
+	l.log(msg)
+	l.Frontend.Fatal(msg)
+}
+
+func (l *loggingFrontend) log(msg string) {
+	fmt.Fprintf(l.w, "%s %s\n", time.Now().Format(time.RFC3339), strings.TrimRight(msg, "\n"))
+}
+
+// Logger records the actions and problems of a run
+type Logger interface {
+	Info(msg string)
+	Warn(msg string)
+	Error(msg string)
+}
+
+type writerLogger struct {
+	m sync.Mutex
+	w io.Writer
+}
+
+// NewLogger returns a Logger writing a line with timestamp and level per message to w
+func NewLogger(w io.Writer) Logger {
+	return &writerLogger{w: w}
+}
+
+func (l *writerLogger) Info(msg string)  { l.log("INFO", msg) }
+func (l *writerLogger) Warn(msg string)  { l.log("WARN", msg) }
+func (l *writerLogger) Error(msg string) { l.log("ERROR", msg) }
+
+func (l *writerLogger) log(level, msg string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	fmt.Fprintf(l.w, "%s %-5s %s\n", time.Now().Format(time.RFC3339), level, strings.TrimRight(msg, "\n"))
+}
+
+type loggerFrontend struct {
+	Frontend
+	l Logger
+}
+
+// WithLogger returns a frontend recording every action as info, warnings as warn and errors as error with l,
+// before passing them on to f
+func WithLogger(f Frontend, l Logger) Frontend {
+	return &loggerFrontend{Frontend: f, l: l}
+}
+
+func (l *loggerFrontend) Unwrap() Frontend {
+	return l.Frontend
+}
+
+func (l *loggerFrontend) Progress(msg string) {
+	if strings.HasPrefix(msg, "Warning") {
+		l.l.Warn(msg)
+	}
+	l.Frontend.Progress(msg)
+}
+
+func (l *loggerFrontend) Fatal(msg string) {
+	l.l.Error(msg)
+	l.Frontend.Fatal(msg)
+}
+
+func (l *loggerFrontend) Action(action, path string, bytes int64) {
+	if bytes > 0 {
+		l.l.Info(fmt.Sprintf("%s %s (%d bytes)", action, path, bytes))
+	} else {
+		l.l.Info(fmt.Sprintf("%s %s", action, path))
+	}
+	l.Frontend.Action(action, path, bytes)
+}
@@ -0,0 +1,91 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLogger records the messages with their level
+type testLogger struct {
+	m     sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) Info(msg string)  { l.log("INFO", msg) }
+func (l *testLogger) Warn(msg string)  { l.log("WARN", msg) }
+func (l *testLogger) Error(msg string) { l.log("ERROR", msg) }
+
+func (l *testLogger) log(level, msg string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.lines = append(l.lines, level+" "+msg)
+}
+
+func TestWithLogger(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := &deniedFS{memFS: newMemFS(), denied: map[string]bool{"/s/denied": true}}
+	fsys.file("/s/a", "abc", mtime)
+	fsys.file("/s/sub/b", "b", mtime)
+	fsys.file("/s/denied", "d", mtime)
+	fsys.file("/d/same", "same", mtime)
+	fsys.file("/s/same", "same", mtime)
+	fsys.file("/d/orphan", "orphan", mtime)
+	cfg := testConfig("/s", "/d")
+	cfg.KeepGoing = true
+	l := &testLogger{}
+	f := &testFrontend{}
+	if _, err := RunFS(context.Background(), cfg, 1, WithLogger(f, l), fsys); !errors.Is(err, ErrFailures) {
+		t.Fatalf("RunFS() error = %v, want %v", err, ErrFailures)
+	}
+	// every action is logged, also those the throttled progress drops
+	want := []string{"INFO copy /d/a (3 bytes)", "INFO copy /d/sub/b (1 bytes)", "INFO delete-file /d/orphan", "INFO identical /d/same", "INFO mkdir /d/sub"}
+	var got []string
+	var errs int
+	for _, line := range l.lines {
+		if strings.HasPrefix(line, "ERROR") {
+			errs++
+			continue
+		}
+		got = append(got, line)
+	}
+	sort.Strings(got)
+	if !stringsEqual(got, want) {
+		t.Errorf("logged %v, want %v", got, want)
+	}
+	if errs != 1 {
+		t.Errorf("%d errors logged, want 1: %v", errs, l.lines)
+	}
+	// the frontend still gets everything
+	if len(f.actions) != len(want) || len(f.fatal) != 1 {
+		t.Errorf("frontend got actions %v, errors %v", f.actions, f.fatal)
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name string
+		log  func(l Logger)
+		want string
+	}{
+		{name: "info", log: func(l Logger) { l.Info("copy a") }, want: `INFO  copy a`},
+		{name: "warn", log: func(l Logger) { l.Warn("Warning: slow") }, want: `WARN  Warning: slow`},
+		{name: "error", log: func(l Logger) { l.Error("failed\n") }, want: `ERROR failed`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			tt.log(NewLogger(&b))
+			re := regexp.MustCompile(`^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\S* ` + regexp.QuoteMeta(tt.want) + "\n$")
+			if !re.MatchString(b.String()) {
+				t.Errorf("logged %q, want timestamp and %q", b.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package mirror
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/binChris/mirror/config"
+)
+
+// verifyManifest hashes the files of cfg.Source listed in the manifest cfg.VerifyManifest, written by -manifest
+// or in sha256sum style, and reports missing, extra and mismatching files
+func verifyManifest(ctx context.Context, cfg config.Config, parallel int, frontend Frontend) error {
+	sums, err := readManifest(cfg.VerifyManifest)
+	if err != nil {
+		return err
+	}
+	m := mirror{
+		fs:       OS,
+		frontend: frontend,
+		throttle: make(chan struct{}, parallel),
+		filters:  cfg.Filters,
+	}
+	manifest, _ := filepath.Abs(cfg.VerifyManifest)
+	var (
+		mu         sync.Mutex
+		verified   int
+		extra      []string
+		mismatches []string
+	)
+	ignore := newIgnoreRules(OS, cfg.IgnoreFile, m.filters)
+	err = filepath.WalkDir(cfg.Source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(cfg.Source, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "." && ignore.excluded(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return ignore.enter(path, rel)
+		}
+		if !d.Type().IsRegular() || m.failed.Load() {
+			return nil
+		}
+		want, listed := sums[rel]
+		if !listed {
+			if abs, _ := filepath.Abs(path); abs != manifest {
+				extra = append(extra, path)
+			}
+			return nil
+		}
+		delete(sums, rel)
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.throttle <- struct{}{}
+			defer func() { <-m.throttle }()
+			m.frontend.Progress(fmt.Sprintf("Verifying %s", path))
+			same := false
+			if inf, err := d.Info(); err != nil {
+				m.fail(err.Error())
+				return
+			} else if want.size < 0 || inf.Size() == want.size {
+				sum, err := hashFile(OS, path)
+				if err != nil {
+					m.fail(err.Error())
+					return
+				}
+				same = hex.EncodeToString(sum) == want.sum
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			verified++
+			if !same {
+				mismatches = append(mismatches, path)
+			}
+		}()
+		return nil
+	})
+	m.wg.Wait()
+	if m.failed.Load() {
+		return m.err
+	}
+	if err != nil {
+		return fmt.Errorf("walk '%s': %w", cfg.Source, err)
+	}
+	// listed files left over were not found
+	missing := make([]string, 0, len(sums))
+	for rel := range sums {
+		missing = append(missing, filepath.Join(cfg.Source, filepath.FromSlash(rel)))
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+	sort.Strings(mismatches)
+	for _, p := range missing {
+		fmt.Printf("Missing: %s\n", p)
+	}
+	for _, p := range extra {
+		fmt.Printf("Extra: %s\n", p)
+	}
+	for _, p := range mismatches {
+		fmt.Printf("Mismatch: %s\n", p)
+	}
+	fmt.Printf("%d files verified, %d missing, %d extra, %d mismatches\n",
+		verified, len(missing), len(extra), len(mismatches))
+	if len(missing)+len(extra)+len(mismatches) > 0 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// manifestEntry is the checksum and size of a file listed in a manifest, the size is -1 if not listed
+type manifestEntry struct {
+	sum  string
+	size int64
+}
+
+// readManifest reads lines of the form "<path>  <sha256 hex>  <size>" as written by -manifest, or
+// "<sha256 hex>  <path>" as written by sha256sum, and returns the entries by slash separated path.
+// In the latter a '*' in front of the path (binary mode) is ignored.
+func readManifest(path string) (map[string]manifestEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open manifest '%s': %w", path, err)
+	}
+	defer f.Close()
+	sums := make(map[string]manifestEntry)
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSuffix(s.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		name, e, ok := parseManifestLine(line)
+		if !ok {
+			sum, rest, found := strings.Cut(line, " ")
+			if !found || !isSHA256(sum) || len(rest) < 2 || (rest[0] != ' ' && rest[0] != '*') {
+				return nil, fmt.Errorf("manifest '%s' line %d: invalid format", path, n)
+			}
+			name, e = rest[1:], manifestEntry{sum: strings.ToLower(sum), size: -1}
+		}
+		sums[strings.TrimPrefix(filepath.ToSlash(name), "./")] = e
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("read manifest '%s': %w", path, err)
+	}
+	return sums, nil
+}
+
+// parseManifestLine parses a line "<path>  <sha256 hex>  <size>" written by -manifest
+func parseManifestLine(line string) (name string, e manifestEntry, ok bool) {
+	i := strings.LastIndex(line, "  ")
+	if i < 0 {
+		return "", e, false
+	}
+	size, err := strconv.ParseInt(line[i+2:], 10, 64)
+	j := strings.LastIndex(line[:i], "  ")
+	if err != nil || size < 0 || j < 1 || !isSHA256(line[j+2:i]) {
+		return "", e, false
+	}
+	return line[:j], manifestEntry{sum: strings.ToLower(line[j+2 : i]), size: size}, true
+}
+
+func isSHA256(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32
+}
+
+// manifestSums are the checksums of the destination files copied or found identical, for -manifest
+type manifestSums struct {
+	m     sync.Mutex
+	sums  map[string][]byte
+	sizes map[string]int64
+}
+
+// addToManifest hashes the destination file at path for -manifest
+func (m *mirror) addToManifest(path string) {
+	if m.manifest == nil {
+		return
+	}
+	sum, err := hashFile(m.fs, path)
+	if err != nil {
+		m.fail(err.Error())
+		return
+	}
+	inf, err := m.fs.Stat(path)
+	if err != nil {
+		m.fail(err.Error())
+		return
+	}
+	rel, err := filepath.Rel(m.dstRoot, path)
+	if err != nil {
+		m.fail(err.Error())
+		return
+	}
+	m.manifest.m.Lock()
+	defer m.manifest.m.Unlock()
+	m.manifest.sums[filepath.ToSlash(rel)] = sum
+	m.manifest.sizes[filepath.ToSlash(rel)] = inf.Size()
+}
+
+// write writes a line "<path>  <sha256 hex>  <size>" per file sorted by path, as read by -verify-manifest
+func (s *manifestSums) write(path string) error {
+	names := make([]string, 0, len(s.sums))
+	for name := range s.sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s  %x  %d\n", name, s.sums[name], s.sizes[name])
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0666); err != nil {
+		return fmt.Errorf("write manifest '%s': %w", path, err)
+	}
+	fmt.Printf("Manifest with %d files written to %s\n", len(names), path)
+	return nil
+}
@@ -0,0 +1,99 @@
+package mirror
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeFile(t, src, "b", "bb")
+	writeFile(t, src, "a b/c", "c")
+	writeFile(t, src, "same", "same")
+	writeFile(t, dst, "same", "same")
+	same, err := os.Stat(filepath.Join(src, "same"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dst, "same"), same.ModTime(), same.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig(src, dst)
+	cfg.Manifest = filepath.Join(t.TempDir(), "manifest")
+	runTest(t, cfg)
+	want := fmt.Sprintf("a b/c  %x  1\nb  %x  2\nsame  %x  4\n", sha256.Sum256([]byte("c")), sha256.Sum256([]byte("bb")), sha256.Sum256([]byte("same")))
+	if got := readFile(t, cfg.Manifest); got != want {
+		t.Errorf("manifest =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestVerifyManifest(t *testing.T) {
+	sum := func(s string) string { return fmt.Sprintf("%x", sha256.Sum256([]byte(s))) }
+	tests := []struct {
+		name     string
+		manifest string
+		change   func(t *testing.T, dir string)
+		want     error
+	}{
+		{name: "unchanged", manifest: fmt.Sprintf("a  %s  1\nsub/b c  %s  2\n", sum("a"), sum("bb"))},
+		{
+			name:     "tampered",
+			manifest: fmt.Sprintf("a  %s  1\nsub/b c  %s  2\n", sum("a"), sum("bb")),
+			change:   func(t *testing.T, dir string) { writeFile(t, dir, "sub/b c", "xx") },
+			want:     ErrMismatch,
+		},
+		{
+			name:     "size changed",
+			manifest: fmt.Sprintf("a  %s  1\nsub/b c  %s  2\n", sum("a"), sum("bb")),
+			change:   func(t *testing.T, dir string) { writeFile(t, dir, "a", "aa") },
+			want:     ErrMismatch,
+		},
+		{
+			name:     "missing",
+			manifest: fmt.Sprintf("a  %s  1\nsub/b c  %s  2\n", sum("a"), sum("bb")),
+			change:   func(t *testing.T, dir string) { os.Remove(filepath.Join(dir, "a")) },
+			want:     ErrMismatch,
+		},
+		{name: "extra", manifest: fmt.Sprintf("a  %s  1\n", sum("a")), want: ErrMismatch},
+		{name: "sha256sum", manifest: fmt.Sprintf("%s  a\n%s *sub/b c\n", sum("a"), sum("bb"))},
+		{name: "sha256sum tampered", manifest: fmt.Sprintf("%s  a\n%s *sub/b c\n", sum("b"), sum("bb")), want: ErrMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, dir, "a", "a")
+			writeFile(t, dir, "sub/b c", "bb")
+			if tt.change != nil {
+				tt.change(t, dir)
+			}
+			cfg := testConfig(dir, "")
+			cfg.VerifyManifest = writeFile(t, t.TempDir(), "manifest", tt.manifest)
+			_, err := Run(context.Background(), cfg, 2, &testFrontend{})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Run() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadManifestInvalid(t *testing.T) {
+	tests := []struct {
+		name, manifest string
+	}{
+		{name: "no hash", manifest: "a  1\n"},
+		{name: "short hash", manifest: "abc  a\n"},
+		{name: "single space", manifest: fmt.Sprintf("%x a\n", sha256.Sum256(nil))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readManifest(writeFile(t, t.TempDir(), "manifest", tt.manifest)); err == nil {
+				t.Error("readManifest() accepted the manifest")
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package mirror
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+)
+
+// alignPerm sets the permissions of the existing dst to those of src and reports whether they changed
+func (m *mirror) alignPerm(src, dst string) bool {
+	sInf, err := m.srcStats.stat(src)
+	if err != nil {
+		m.fail(fmt.Sprintf("Cannot get file info for '%s': %s", src, err))
+		return false
+	}
+	dInf, err := m.fs.Stat(dst)
+	if err != nil {
+		m.fail(fmt.Sprintf("Cannot get file info for '%s': %s", dst, err))
+		return false
+	}
+	if sInf.Mode().Perm() == dInf.Mode().Perm() {
+		return false
+	}
+	if err := m.fs.Chmod(dst, sInf.Mode().Perm()); err != nil {
+		m.fail(fmt.Sprintf("Cannot set mode of '%s': %s", dst, err))
+		return false
+	}
+	return true
+}
+
+// dirPerm returns the permissions for creating the copy of the source dir e, which the type bits of a DirEntry lack.
+// The owner can always write, so the dir can be filled; -perms aligns it on the next run
+func dirPerm(e fs.DirEntry) fs.FileMode {
+	if inf, err := e.Info(); err == nil {
+		return inf.Mode().Perm() | 0700
+	}
+	return 0777
+}
+
+// setOwner gives dst the owner and group of the source with file info sInf. Not permitted unless running
+// privileged, so a failure is a warning
+func (m *mirror) setOwner(sInf fs.FileInfo, dst string) {
+	uid, gid, ok := fileOwner(sInf)
+	if !ok {
+		return
+	}
+	if err := m.fs.Lchown(dst, uid, gid); err != nil {
+		m.frontend.Progress(fmt.Sprintf("Warning: cannot set owner of '%s': %s", dst, err))
+	}
+}
+
+// alignMetadata brings mode, mtime, owner and xattrs of dst in line with src and reports whether anything changed
+func (m *mirror) alignMetadata(src, dst string) bool {
+	sInf, err := m.srcStats.stat(src)
+	if err != nil {
+		m.fail(fmt.Sprintf("Cannot get file info for '%s': %s", src, err))
+		return false
+	}
+	dInf, err := m.fs.Stat(dst)
+	if err != nil {
+		m.fail(fmt.Sprintf("Cannot get file info for '%s': %s", dst, err))
+		return false
+	}
+	changed := false
+	if sInf.Mode().Perm() != dInf.Mode().Perm() {
+		if err := m.fs.Chmod(dst, sInf.Mode().Perm()); err != nil {
+			m.fail(fmt.Sprintf("Cannot set mode of '%s': %s", dst, err))
+			return false
+		}
+		changed = true
+	}
+	if m.copyOpts.preservesMtime() && !sInf.ModTime().Equal(dInf.ModTime()) {
+		if err := m.fs.Chtimes(dst, sInf.ModTime(), sInf.ModTime()); err != nil {
+			m.fail(fmt.Sprintf("Cannot set modification time of '%s': %s", dst, err))
+			return false
+		}
+		changed = true
+	}
+	if uid, gid, ok := fileOwner(sInf); ok {
+		if dUID, dGID, _ := fileOwner(dInf); uid != dUID || gid != dGID {
+			// not permitted unless running privileged, don't fail the run
+			if err := m.fs.Lchown(dst, uid, gid); err != nil {
+				m.frontend.Progress(fmt.Sprintf("Warning: cannot set owner of '%s': %s", dst, err))
+			} else {
+				changed = true
+			}
+		}
+	}
+	xChanged, err := alignXattrs(m.fs, src, dst)
+	if err != nil {
+		m.frontend.Progress(fmt.Sprintf("Warning: cannot set extended attributes of '%s': %s", dst, err))
+	}
+	return changed || xChanged
+}
+
+// alignXattrs copies the extended attributes of src to dst and removes those only present on dst.
+// A no-op unless fsys supports extended attributes
+func alignXattrs(fsys FS, src, dst string) (bool, error) {
+	x, ok := fsys.(XattrFS)
+	if !ok {
+		return false, nil
+	}
+	sAttrs, err := x.Listxattr(src)
+	if err != nil {
+		return false, err
+	}
+	dAttrs, err := x.Listxattr(dst)
+	if err != nil {
+		return false, err
+	}
+	sNames := make(map[string]struct{}, len(sAttrs))
+	for _, attr := range sAttrs {
+		sNames[attr] = struct{}{}
+	}
+	dNames := make(map[string]struct{}, len(dAttrs))
+	changed := false
+	for _, attr := range dAttrs {
+		dNames[attr] = struct{}{}
+		if _, exInSrc := sNames[attr]; !exInSrc {
+			if err := x.Removexattr(dst, attr); err != nil {
+				return changed, err
+			}
+			changed = true
+		}
+	}
+	for _, attr := range sAttrs {
+		sVal, err := x.Getxattr(src, attr)
+		if err != nil {
+			return changed, err
+		}
+		if _, exInDst := dNames[attr]; exInDst {
+			if dVal, err := x.Getxattr(dst, attr); err == nil && bytes.Equal(sVal, dVal) {
+				continue
+			}
+		}
+		if err := x.Setxattr(dst, attr, sVal); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
@@ -0,0 +1,94 @@
+package mirror
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+func TestPerms(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name              string
+		perms             bool
+		wantDir, wantFile fs.FileMode
+	}{
+		{name: "default", wantDir: 0755, wantFile: 0644},
+		{name: "perms", perms: true, wantDir: 0700, wantFile: 0600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := newMemFS()
+			fsys.file("/s/sub/f", "f", mtime)
+			fsys.file("/d/sub/f", "f", mtime)
+			// the source modes changed after the last run
+			for p, mode := range map[string]fs.FileMode{"/s/sub": 0700, "/s/sub/f": 0600} {
+				if err := fsys.Chmod(p, mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg := testConfig("/s", "/d")
+			cfg.Perms = tt.perms
+			f := &testFrontend{}
+			if _, err := RunFS(context.Background(), cfg, 1, f, fsys); err != nil {
+				t.Fatalf("RunFS() error = %v, fatal %v", err, f.fatal)
+			}
+			for p, want := range map[string]fs.FileMode{"/d/sub": tt.wantDir, "/d/sub/f": tt.wantFile} {
+				inf, err := fsys.Stat(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := inf.Mode().Perm(); got != want {
+					t.Errorf("mode of %s = %v, want %v", p, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCopiedFileMode(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name     string
+		existing bool // a 0644 destination file is overwritten
+		umask    int
+		want     fs.FileMode
+	}{
+		{name: "new", umask: -1, want: 0755},
+		{name: "overwritten", existing: true, umask: -1, want: 0755},
+		{name: "new with umask", umask: 0027, want: 0750},
+		{name: "overwritten with umask", existing: true, umask: 0077, want: 0700},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := newMemFS()
+			fsys.file("/s/f", "new", mtime)
+			if err := fsys.Chmod("/s/f", 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := fsys.Mkdir("/d", 0755); err != nil {
+				t.Fatal(err)
+			}
+			if tt.existing {
+				fsys.file("/d/f", "old", mtime.Add(-time.Hour))
+			}
+			cfg := testConfig("/s", "/d")
+			cfg.Umask = tt.umask
+			f := &testFrontend{}
+			if _, err := RunFS(context.Background(), cfg, 1, f, fsys); err != nil {
+				t.Fatalf("RunFS() error = %v, fatal %v", err, f.fatal)
+			}
+			inf, err := fsys.Stat("/d/f")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := inf.Mode().Perm(); got != tt.want {
+				t.Errorf("mode = %v, want %v", got, tt.want)
+			}
+			if got := fsys.tree("/d")["f"]; got != "new" {
+				t.Errorf("content = %q", got)
+			}
+		})
+	}
+}
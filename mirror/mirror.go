@@ -1,205 +1,1215 @@
 package mirror
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/binChris/mirror/config"
+	"github.com/binChris/mirror/humanize"
 )
 
 type Frontend interface {
 	Progress(msg string)
+	Scanning(files, dirs uint64)
+	// Fatal reports an error which stops the run, Run returns ErrFatal. With -keep-going the run goes on
 	Fatal(msg string)
 	Choice(msg string, options string) rune
+	// SetTotals announces the number of source files and their bytes, counted before mirroring with -progress
+	SetTotals(files int, bytes int64)
+	// Completed reports the files and bytes copied or found identical so far, with -progress
+	Completed(files int, bytes int64)
+	// Action reports what was done to the destination path: mkdir, copy, overwrite, symlink, link (from a -link-dest dir),
+	// identical, move (with -detect-moves), skip (declined), delete-file or delete-dir. bytes is the size of copies
+	Action(action, path string, bytes int64)
 }
 
 type mirror struct {
-	frontend       Frontend
-	m              sync.Mutex
-	queue          []config.Config
-	throttle       chan struct{}
-	wg             sync.WaitGroup
-	dirsCreated    uint64
-	dirsDeleted    uint64
-	filesCopied    uint64
-	filesDeleted   uint64
-	filesIdentical uint64
-}
-
-// Run will start the mirroring process with 'parallel' processes and return when done
-func Run(cfg config.Config, parallel int, frontend Frontend) {
+	ctx               context.Context
+	fs                FS
+	frontend          Frontend
+	m                 sync.Mutex
+	queue             []config.Config
+	queued            *sync.Cond
+	scans             int
+	scanThrottle      chan struct{}
+	throttle          chan struct{}
+	wg                sync.WaitGroup
+	stats             Stats
+	filesChanged      uint64
+	filesLinked       uint64
+	filesScanned      uint64
+	dirsScanned       uint64
+	dirLinksSkipped   uint64
+	filesAligned      uint64
+	crossMountSkipped uint64
+	destDev           *uint64
+	dirSemsM          sync.Mutex
+	dirSems           map[string]chan struct{}
+	subtrees          *subtreeCache
+	subtreesSkipped   uint64
+	srcStats          *statCache
+	copyOpts          copyOptions
+	srcRoot           string
+	filters           []config.FilterRule
+	hardlinks         *hardlinks
+	deleteThrottle    chan struct{}
+	largest           *largestFiles
+	dstRoot           string
+	plan              *plan
+	mmapCompare       bool
+	mmapMin           int64
+	reserveBytes      int64
+	reservePercent    float64
+	reserveHit        atomic.Bool
+	pendingBytes      int64
+	flatNames         map[string]string
+	deadline          time.Time
+	stopped           atomic.Bool
+	srcGone           atomic.Bool
+	failed            atomic.Bool
+	failOnce          sync.Once
+	err               error
+	dryRun            bool
+	checksum          bool
+	followLinks       bool
+	minSize           int64
+	maxSize           int64
+	progress          bool
+	filesDone         int64
+	bytesDone         int64
+	scanErrors        []string
+	keepGoing         bool
+	failuresM         sync.Mutex
+	failures          []string
+	moves             *moves
+	filesMoved        uint64
+	// the dir of this run in the -trash dir
+	trash        string
+	skippedNewer uint64
+	permsAligned uint64
+	deleteAfter  bool
+	afterM       sync.Mutex
+	after        []pendingDeletes
+	owner        bool
+	manifest     *manifestSums
+	stable       time.Duration
+	unstable     uint64
+	// dirs deeper than maxDepth below the source are not descended into, -1 = unlimited
+	maxDepth       int
+	caseCollisions uint64
+	retries        int
+	retryDelay     time.Duration
+	// a prompt was answered with no, protected by m
+	declined bool
+}
+
+// changedRetries is the number of additional attempts to copy a file that changed during transfer
+const changedRetries = 2
+
+// transfer is a file to be copied, identified by its name in the source and destination dir
+type transfer struct {
+	src, dst string
+	// the source is a symlink to be recreated
+	link bool
+	// the file does not exist in the destination
+	missing bool
+}
+
+// action is the name of the copy for Frontend.Action
+func (t transfer) action() string {
+	if t.missing {
+		return "copy"
+	}
+	return "overwrite"
+}
+
+var (
+	errFileChanged  = errors.New("file changed during transfer")
+	errTooManyLinks = errors.New("too many levels of symbolic links")
+)
+
+// ErrTimeLimit is returned by Run if the run was stopped before completion due to the time limit
+var ErrTimeLimit = errors.New("stopped due to time limit")
+
+// ErrFilesChanged is returned by Run if files changing during transfer were ignored
+var ErrFilesChanged = errors.New("some files changed during transfer")
+
+// ErrScanErrors is returned by Run if dirs were skipped because they could not be read
+var ErrScanErrors = errors.New("some dirs could not be read")
+
+// ErrFailures is returned by Run if files or dirs failed with -keep-going
+var ErrFailures = errors.New("some files or dirs failed")
+
+// ErrFatal is returned by Run if an error stopped the run, the error has been reported with Frontend.Fatal
+var ErrFatal = errors.New("stopped after an error")
+
+// ErrQuit is returned by Run if the user chose to quit at a prompt
+var ErrQuit = errors.New("quit by user")
+
+// ErrSourceGone is returned by Run if the source dir became inaccessible during the run
+var ErrSourceGone = errors.New("source dir disappeared")
+
+// Stats are the counters of a mirror run
+type Stats struct {
+	DirsCreated    uint64
+	DirsDeleted    uint64
+	FilesCopied    uint64
+	FilesDeleted   uint64
+	FilesIdentical uint64
+	BytesCopied    uint64
+	Duration       time.Duration
+}
+
+// throughput returns the bytes copied, the duration and the resulting rate, e.g. Copied 3.2 GiB in 41s (80.0 MiB/s)
+func (s Stats) throughput() string {
+	d := s.Duration.Round(time.Millisecond)
+	if d >= time.Second {
+		d = d.Round(time.Second)
+	}
+	rate := float64(s.BytesCopied)
+	if s.Duration > 0 {
+		rate /= s.Duration.Seconds()
+	}
+	return fmt.Sprintf("Copied %s in %s (%s/s)", humanize.Bytes(float64(s.BytesCopied)), d, humanize.Bytes(rate))
+}
+
+// Run will start the mirroring process with 'parallel' processes and return when done.
+// Modes other than mirroring and applying a plan return zero Stats.
+// When ctx is cancelled no new work is started, copies in progress are finished and ctx.Err() is returned.
+func Run(ctx context.Context, cfg config.Config, parallel int, frontend Frontend) (Stats, error) {
+	return RunFS(ctx, cfg, parallel, frontend, OS)
+}
+
+// RunFS is Run with the mirror pass on fsys. The other modes and -atomic-dir use the OS.
+func RunFS(ctx context.Context, cfg config.Config, parallel int, frontend Frontend, fsys FS) (Stats, error) {
 	if parallel < 1 {
 		parallel = 1
 	}
+	if cfg.Umask >= 0 {
+		defer setUmask(setUmask(cfg.Umask))
+	}
+	if cfg.Relative {
+		// only modes changing the destination create the path
+		create := !cfg.List && !cfg.VerifyExisting && cfg.PlanOut == "" && !cfg.DryRun
+		var err error
+		if cfg.Destination, err = relativeDestination(cfg, create); err != nil {
+			return Stats{}, err
+		}
+	}
+	if cfg.List {
+		return Stats{}, list(cfg, frontend)
+	}
+	if cfg.VerifyManifest != "" {
+		return Stats{}, verifyManifest(ctx, cfg, parallel, frontend)
+	}
+	if cfg.VerifyExisting {
+		return Stats{}, verifyExisting(ctx, cfg, parallel, frontend)
+	}
+	if cfg.ApplyPlan != "" {
+		return applyPlan(ctx, cfg, frontend)
+	}
+	if cfg.AtomicDir && !cfg.DryRun {
+		return runAtomic(ctx, cfg, parallel, frontend)
+	}
+	return run(ctx, cfg, parallel, frontend, fsys)
+}
+
+func run(ctx context.Context, cfg config.Config, parallel int, frontend Frontend, fsys FS) (Stats, error) {
+	start := time.Now()
 	m := mirror{
-		frontend: frontend,
-		queue:    make([]config.Config, 0, 100),
-		throttle: make(chan struct{}, parallel),
+		ctx:            ctx,
+		fs:             fsys,
+		frontend:       frontend,
+		queue:          make([]config.Config, 0, 100),
+		throttle:       make(chan struct{}, parallel),
+		flatNames:      make(map[string]string),
+		dirSems:        make(map[string]chan struct{}),
+		srcStats:       newStatCache(fsys),
+		copyOpts:       copyOptionsFrom(cfg),
+		srcRoot:        cfg.Source,
+		dstRoot:        cfg.Destination,
+		filters:        cfg.Filters,
+		mmapCompare:    cfg.MmapCompare,
+		mmapMin:        cfg.MmapMinSize,
+		reserveBytes:   cfg.ReserveBytes,
+		reservePercent: cfg.ReservePercent,
+		dryRun:         cfg.DryRun,
+		checksum:       cfg.Checksum,
+		followLinks:    cfg.FollowSymlinks,
+		minSize:        cfg.MinSize,
+		maxSize:        cfg.MaxSize,
+		progress:       cfg.Progress,
+		keepGoing:      cfg.KeepGoing,
+		deleteAfter:    cfg.DeleteAfter,
+		owner:          cfg.Owner,
+		stable:         cfg.Stable,
+		maxDepth:       cfg.MaxDepth,
+		retries:        cfg.Retries,
+		retryDelay:     cfg.RetryDelay,
+	}
+	m.queued = sync.NewCond(&m.m)
+	if p := copyProgressOf(frontend); p != nil {
+		m.copyOpts.progress = p.CopyProgress
+	}
+	if cfg.DetectMoves && cfg.PlanOut == "" {
+		m.moves = newMoves()
+	}
+	if cfg.Trash != "" && cfg.PlanOut == "" {
+		var err error
+		if m.trash, err = trashRunDir(cfg.Trash, cfg.Destination); err != nil {
+			return Stats{}, err
+		}
+	}
+	if cfg.Manifest != "" && cfg.PlanOut == "" && !cfg.DryRun {
+		m.manifest = &manifestSums{sums: make(map[string][]byte), sizes: make(map[string]int64)}
+	}
+	if cfg.PlanOut != "" {
+		m.plan = &plan{Source: cfg.Source, Destination: cfg.Destination}
+	}
+	if cfg.TimeLimit > 0 {
+		m.deadline = time.Now().Add(cfg.TimeLimit)
+	}
+	if cfg.NoCrossMountDelete {
+		inf, err := os.Stat(cfg.Destination)
+		if err != nil {
+			return Stats{}, fmt.Errorf("get file info for '%s': %w", cfg.Destination, err)
+		}
+		if dev, ok := deviceOf(inf); ok {
+			m.destDev = &dev
+		}
+	}
+	if cfg.DeleteParallel < 1 {
+		cfg.DeleteParallel = 1
+	}
+	m.deleteThrottle = make(chan struct{}, cfg.DeleteParallel)
+	if cfg.ScanParallel < 1 {
+		cfg.ScanParallel = 1
+	}
+	m.scanThrottle = make(chan struct{}, cfg.ScanParallel)
+	if cfg.Top > 0 {
+		m.largest = &largestFiles{n: cfg.Top}
+	}
+	if cfg.WarnHardlinks {
+		m.hardlinks = newHardlinks()
+	}
+	if cfg.SubtreeCache != "" {
+		var err error
+		if m.subtrees, err = loadSubtreeCache(cfg.SubtreeCache, cfg, m.srcStats); err != nil {
+			return Stats{}, err
+		}
+		if m.subtrees.unchanged(cfg.Source) {
+			fmt.Println("Source unchanged since last run")
+			return Stats{}, nil
+		}
+	}
+	var usage *diskUsage
+	if cfg.UsageReport {
+		u, err := usageOf(cfg.Destination)
+		if err != nil {
+			return Stats{}, err
+		}
+		usage = &u
+	}
+	if m.progress {
+		m.frontend.SetTotals(m.countSource(cfg))
+	}
+	m.rampUp(cfg.RampUp)
+	if cfg.RateReport > 0 {
+		defer m.reportRate(cfg.RateReport)()
 	}
 	m.add([]config.Config{cfg})
-	for {
+	for !m.timeUp() && !m.srcGone.Load() && !m.failed.Load() && ctx.Err() == nil {
 		cfg, ok := m.get()
 		if !ok {
 			break
 		}
-		m.process(cfg)
+		m.scanThrottle <- struct{}{}
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			defer func() { <-m.scanThrottle }()
+			m.process(cfg)
+			m.scanned()
+		}()
 	}
 	m.wg.Wait()
+	if m.moves != nil && !m.failed.Load() && ctx.Err() == nil && !m.stopped.Load() && !m.srcGone.Load() {
+		m.applyMoves()
+		m.wg.Wait()
+	}
+	if len(m.after) > 0 {
+		if !m.failed.Load() && ctx.Err() == nil && !m.stopped.Load() && !m.srcGone.Load() {
+			m.deleteAfter = false
+			for _, p := range m.after {
+				m.startDeletes(p.cfg, p.dirs, p.files)
+			}
+			m.wg.Wait()
+		} else {
+			fmt.Println("Deletes skipped as the run did not complete")
+		}
+	}
+	m.stats.Duration = time.Since(start)
 	fmt.Printf("%d/%d dirs created/deleted, %d/%d files copied/deleted, %d files identical\n",
-		m.dirsCreated, m.dirsDeleted,
-		m.filesCopied, m.filesDeleted,
-		m.filesIdentical,
+		m.stats.DirsCreated, m.stats.DirsDeleted,
+		m.stats.FilesCopied, m.stats.FilesDeleted,
+		m.stats.FilesIdentical,
 	)
+	if m.stats.BytesCopied > 0 && m.dryRun {
+		fmt.Printf("%s would be copied\n", humanize.Bytes(float64(m.stats.BytesCopied)))
+	} else if m.stats.BytesCopied > 0 {
+		fmt.Println(m.stats.throughput())
+	}
+	if m.caseCollisions > 0 {
+		fmt.Printf("%d source entries skipped as their names differ only in case from another\n", m.caseCollisions)
+	}
+	if m.unstable > 0 {
+		fmt.Printf("%d files skipped as they were being written, left to the next run\n", m.unstable)
+	}
+	if m.skippedNewer > 0 {
+		fmt.Printf("%d files skipped as the destination is not older (-update)\n", m.skippedNewer)
+	}
+	if m.filesMoved > 0 {
+		fmt.Printf("%d files moved within the destination instead of copied\n", m.filesMoved)
+	}
+	if m.filesLinked > 0 {
+		fmt.Printf("%d files hard-linked from reference dirs\n", m.filesLinked)
+	}
+	if m.permsAligned > 0 {
+		fmt.Printf("%d existing files and dirs with permissions aligned\n", m.permsAligned)
+	}
+	if m.filesAligned > 0 {
+		fmt.Printf("%d identical files with metadata aligned\n", m.filesAligned)
+	}
+	if m.crossMountSkipped > 0 {
+		fmt.Printf("%d deletions skipped on a different device\n", m.crossMountSkipped)
+	}
+	if m.dirLinksSkipped > 0 {
+		fmt.Printf("%d symlinks to dirs skipped\n", m.dirLinksSkipped)
+	}
+	if m.filesChanged > 0 {
+		fmt.Printf("%d files changed during transfer\n", m.filesChanged)
+	}
+	if m.hardlinks != nil {
+		if inodes, bytes := m.hardlinks.extra(); inodes > 0 {
+			fmt.Printf("%d hard-linked source files are copied separately, using %d extra bytes\n", inodes, bytes)
+		}
+	}
+	if m.largest != nil {
+		fmt.Println("Largest files copied:")
+		for _, f := range m.largest.sorted() {
+			fmt.Printf("%15d %s\n", f.size, f.path)
+		}
+	}
+	if m.subtreesSkipped > 0 {
+		fmt.Printf("%d unchanged subtrees skipped\n", m.subtreesSkipped)
+	}
+	if usage != nil {
+		m.printUsage(cfg.Destination, *usage)
+	}
+	if m.plan != nil && !m.failed.Load() && ctx.Err() == nil {
+		if err := m.plan.write(cfg.PlanOut); err != nil {
+			return m.stats, err
+		}
+	}
+	if m.manifest != nil && !m.failed.Load() && ctx.Err() == nil && !m.stopped.Load() && !m.srcGone.Load() {
+		if err := m.manifest.write(cfg.Manifest); err != nil {
+			return m.stats, err
+		}
+	}
+	if len(m.scanErrors) > 0 {
+		sort.Strings(m.scanErrors)
+		fmt.Printf("%d dirs skipped because they could not be read:\n", len(m.scanErrors))
+		for _, e := range m.scanErrors {
+			fmt.Println(e)
+		}
+	}
+	if len(m.failures) > 0 {
+		fmt.Printf("%d errors:\n", len(m.failures))
+		for _, e := range m.failures {
+			fmt.Println(e)
+		}
+	}
+	// skipped dirs, and those whose changes were declined or only planned, must not be remembered as unchanged
+	if m.subtrees != nil && !m.dryRun && m.plan == nil && !m.declined && !m.failed.Load() && ctx.Err() == nil && !m.stopped.Load() && !m.srcGone.Load() && len(m.scanErrors) == 0 && len(m.failures) == 0 {
+		if err := m.subtrees.save(cfg.SubtreeCache); err != nil {
+			return m.stats, err
+		}
+	}
+	if cfg.SummaryJSON {
+		if err := m.writeSummaryJSON(os.Stderr); err != nil {
+			return m.stats, fmt.Errorf("write summary: %w", err)
+		}
+	}
+	if m.failed.Load() {
+		return m.stats, m.err
+	}
+	if err := ctx.Err(); err != nil {
+		fmt.Printf("Interrupted, %d dirs not processed\n", len(m.queue))
+		return m.stats, err
+	}
+	if m.srcGone.Load() {
+		fmt.Printf("Stopped because source dir '%s' disappeared\n", m.srcRoot)
+		return m.stats, ErrSourceGone
+	}
+	if m.stopped.Load() {
+		fmt.Printf("Stopped after time limit of %s, %d dirs not processed\n", cfg.TimeLimit, len(m.queue))
+		return m.stats, ErrTimeLimit
+	}
+	if m.reserveHit.Load() {
+		fmt.Println("Stopped copying to keep the free space reserve on the destination")
+		return m.stats, ErrReserve
+	}
+	if len(m.failures) > 0 {
+		return m.stats, ErrFailures
+	}
+	if len(m.scanErrors) > 0 {
+		return m.stats, ErrScanErrors
+	}
+	if m.filesChanged > 0 {
+		return m.stats, ErrFilesChanged
+	}
+	return m.stats, nil
+}
+
+// timeUp reports whether the time limit is exceeded, in which case no new work must be started
+func (m *mirror) timeUp() bool {
+	if m.deadline.IsZero() || time.Now().Before(m.deadline) {
+		return false
+	}
+	m.stopped.Store(true)
+	return true
 }
 
 func (m *mirror) add(cfgs []config.Config) {
 	m.m.Lock()
 	defer m.m.Unlock()
 	m.queue = append(m.queue, cfgs...)
+	m.queued.Broadcast()
 }
 
 func (m *mirror) get() (config.Config, bool) {
 	m.m.Lock()
 	defer m.m.Unlock()
+	// dirs being scanned may still add their sub dirs
+	for len(m.queue) == 0 && m.scans > 0 {
+		m.queued.Wait()
+	}
 	if len(m.queue) == 0 {
 		return config.Config{}, false
 	}
 	cfg := m.queue[0]
 	m.queue = m.queue[1:]
+	m.scans++
 	return cfg, true
 }
 
+// scanned marks a dir returned by get as processed
+func (m *mirror) scanned() {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.scans--
+	m.queued.Broadcast()
+}
+
 func (m *mirror) process(cfg config.Config) {
-	m.throttle <- struct{}{}
-	defer func() { <-m.throttle }()
 	m.frontend.Progress(fmt.Sprintf("Mirroring %s to %s", cfg.Source, cfg.Destination))
 	subs, delDirs, delFiles, cpFiles := m.compareSourceWithDestination(cfg)
 	m.add(subs)
+	if m.plan != nil {
+		for _, d := range delDirs {
+			m.record("delete-dir", "", filepath.Join(cfg.Destination, d))
+			atomic.AddUint64(&m.stats.DirsDeleted, 1)
+		}
+		for _, f := range delFiles {
+			m.record("delete-file", "", filepath.Join(cfg.Destination, f))
+			atomic.AddUint64(&m.stats.FilesDeleted, 1)
+		}
+		for _, cp := range cpFiles {
+			action := "copy"
+			if cp.link {
+				action = "symlink"
+			}
+			m.record(action, filepath.Join(cfg.Source, cp.src), filepath.Join(cfg.Destination, cp.dst))
+			atomic.AddUint64(&m.stats.FilesCopied, 1)
+		}
+		return
+	}
+	if m.moves != nil {
+		// deletes and new files wait until all dirs are compared, moved files are renamed
+		cpFiles = m.moves.hold(cfg, delDirs, delFiles, cpFiles)
+		delDirs, delFiles = nil, nil
+	}
+	m.startDeletes(cfg, delDirs, delFiles)
+	m.startCopies(cfg, cpFiles)
+}
+
+// startDeletes deletes the dirs and files of the destination dir of cfg concurrently.
+// With -delete-after they are held until all copies are done
+func (m *mirror) startDeletes(cfg config.Config, delDirs, delFiles []string) {
+	if m.deleteAfter && len(delDirs)+len(delFiles) > 0 {
+		m.afterM.Lock()
+		defer m.afterM.Unlock()
+		m.after = append(m.after, pendingDeletes{cfg, delDirs, delFiles})
+		return
+	}
 	for _, d := range delDirs {
 		m.wg.Add(1)
 		go func(d string) {
 			defer m.wg.Done()
-			// delete as soon as possible, don't throttle
+			// delete as soon as possible, independent of copies
+			m.deleteThrottle <- struct{}{}
+			defer func() { <-m.deleteThrottle }()
+			if m.ctx.Err() != nil {
+				return
+			}
 			d = filepath.Join(cfg.Destination, d)
-			if err := os.RemoveAll(d); err != nil {
-				m.frontend.Fatal(fmt.Sprintf("Cannot delete dir '%s': %s", d, err))
+			m.frontend.Progress(fmt.Sprintf("Deleting dir %s", d))
+			err := m.remove(d, true)
+			if errors.Is(err, errCrossMount) {
+				return
+			}
+			if err != nil {
+				m.fail(fmt.Sprintf("Cannot delete dir '%s': %s", d, err))
+				return
 			}
-			atomic.AddUint64(&m.dirsDeleted, 1)
+			atomic.AddUint64(&m.stats.DirsDeleted, 1)
+			m.frontend.Action("delete-dir", d, 0)
 		}(d)
 	}
 	for _, f := range delFiles {
 		m.wg.Add(1)
 		go func(f string) {
 			defer m.wg.Done()
-			// delete as soon as possible, don't throttle
+			// delete as soon as possible, independent of copies
+			m.deleteThrottle <- struct{}{}
+			defer func() { <-m.deleteThrottle }()
+			if m.ctx.Err() != nil {
+				return
+			}
 			f = filepath.Join(cfg.Destination, f)
-			if err := os.Remove(f); err != nil {
-				m.frontend.Fatal(fmt.Sprintf("Cannot delete file '%s': %s", f, err))
+			m.frontend.Progress(fmt.Sprintf("Deleting file %s", f))
+			err := m.remove(f, false)
+			if errors.Is(err, errCrossMount) {
+				return
+			}
+			if err != nil {
+				m.fail(fmt.Sprintf("Cannot delete file '%s': %s", f, err))
+				return
 			}
-			atomic.AddUint64(&m.filesDeleted, 1)
+			atomic.AddUint64(&m.stats.FilesDeleted, 1)
+			m.frontend.Action("delete-file", f, 0)
 		}(f)
 	}
+}
+
+// startCopies copies the files from the source to the destination dir of cfg concurrently
+func (m *mirror) startCopies(cfg config.Config, cpFiles []transfer) {
+	var turn chan struct{}
+	if cfg.CopyOrder == "locality" {
+		m.sortByLocality(cfg.Source, cpFiles)
+		// each copy starts after its predecessor
+		turn = make(chan struct{})
+		close(turn)
+	}
 	for _, cp := range cpFiles {
 		m.wg.Add(1)
-		go func(cp string) {
+		next := turn
+		if turn != nil {
+			next = make(chan struct{})
+		}
+		go func(cp transfer, turn, next chan struct{}) {
 			defer m.wg.Done()
+			if turn != nil {
+				<-turn
+			}
+			if cfg.PerDirConcurrency > 0 {
+				sem := m.dirSemaphore(cfg.Destination, cfg.PerDirConcurrency)
+				sem <- struct{}{}
+				defer func() { <-sem }()
+			}
 			// throttle copying files
 			m.throttle <- struct{}{}
 			defer func() { <-m.throttle }()
-			s := filepath.Join(cfg.Source, cp)
-			d := filepath.Join(cfg.Destination, cp)
+			if next != nil {
+				close(next)
+			}
+			if m.timeUp() || m.srcGone.Load() || m.failed.Load() || m.ctx.Err() != nil {
+				return
+			}
+			s := filepath.Join(cfg.Source, cp.src)
+			d := filepath.Join(cfg.Destination, cp.dst)
+			if m.dryRun {
+				m.frontend.Progress(fmt.Sprintf("Copy %s to %s\n", s, d))
+				// the bytes a real run would copy, none for links
+				var size int64
+				if !cp.link {
+					if inf, err := m.srcStats.stat(s); err == nil {
+						size = inf.Size()
+					}
+				}
+				atomic.AddUint64(&m.stats.FilesCopied, 1)
+				atomic.AddUint64(&m.stats.BytesCopied, uint64(size))
+				m.completed(s)
+				m.frontend.Action(cp.action(), d, size)
+				return
+			}
+			if cp.link {
+				m.frontend.Progress(fmt.Sprintf("Link %s to %s\n", d, s))
+				if err := copySymlink(m.fs, s, d); err != nil {
+					m.fail(err.Error())
+					return
+				}
+				if m.owner {
+					if sInf, err := m.fs.Lstat(s); err == nil {
+						m.setOwner(sInf, d)
+					}
+				}
+				atomic.AddUint64(&m.stats.FilesCopied, 1)
+				m.completed(s)
+				m.frontend.Action("symlink", d, 0)
+				return
+			}
+			if m.linkReference(cfg.LinkDest, cp.dst, s, d) {
+				atomic.AddUint64(&m.filesLinked, 1)
+				m.addToManifest(d)
+				m.completed(s)
+				m.frontend.Action("link", d, 0)
+				return
+			}
+			if m.stable > 0 && !m.isStable(s) {
+				m.frontend.Progress(fmt.Sprintf("Skipping %s, it is being written", s))
+				atomic.AddUint64(&m.unstable, 1)
+				m.frontend.Action("skip", d, 0)
+				return
+			}
+			inf, err := m.srcStats.stat(s)
+			if err != nil {
+				if m.sourceGone() {
+					return
+				}
+				m.fail(fmt.Sprintf("Cannot get file info for '%s': %s", s, err))
+				return
+			}
+			if !m.reserveSpace(cfg.Destination, inf.Size()) {
+				return
+			}
+			defer m.releaseSpace(inf.Size())
 			m.frontend.Progress(fmt.Sprintf("Copy %s to %s\n", s, d))
-			if err := copyFile(s, d); err != nil {
-				m.frontend.Fatal(err.Error())
+			err = m.copyRetrying(s, d)
+			for i := 0; i < changedRetries && errors.Is(err, errFileChanged); i++ {
+				m.srcStats.invalidate(s)
+				m.frontend.Progress(fmt.Sprintf("Retry %s: %s", s, err))
+				err = m.copyRetrying(s, d)
+			}
+			if errors.Is(err, errFileChanged) && cfg.IgnoreChanged {
+				m.srcStats.invalidate(s)
+				m.frontend.Progress(fmt.Sprintf("Warning: %s", err))
+				atomic.AddUint64(&m.filesChanged, 1)
+			} else if err != nil {
+				if m.sourceGone() {
+					return
+				}
+				m.fail(err.Error())
+				return
+			}
+			if m.owner {
+				m.setOwner(inf, d)
+			}
+			m.addToManifest(d)
+			atomic.AddUint64(&m.stats.FilesCopied, 1)
+			atomic.AddUint64(&m.stats.BytesCopied, uint64(inf.Size()))
+			m.completed(s)
+			m.frontend.Action(cp.action(), d, inf.Size())
+			if m.largest != nil {
+				if inf, err := m.fs.Stat(d); err == nil {
+					m.largest.add(d, inf.Size())
+				}
 			}
-			atomic.AddUint64(&m.filesCopied, 1)
-		}(cp)
+		}(cp, turn, next)
+		turn = next
 	}
 }
 
-func (m *mirror) compareSourceWithDestination(cfg config.Config) (subs []config.Config, delDirs, delFiles, cpFiles []string) {
-	sDirs, sFiles, err := readDir(cfg.Source, false)
+// sortByLocality orders the files by inode number, which mostly follows their position on disk
+func (m *mirror) sortByLocality(dir string, files []transfer) {
+	inodes := make(map[string]uint64, len(files))
+	for _, f := range files {
+		inf, err := m.srcStats.stat(filepath.Join(dir, f.src))
+		if err != nil {
+			m.fail(fmt.Sprintf("Cannot get file info for '%s': %s", filepath.Join(dir, f.src), err))
+			return
+		}
+		if id, _, ok := fileID(inf); ok {
+			inodes[f.src] = id[1]
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return inodes[files[i].src] < inodes[files[j].src] })
+}
+
+// dirSemaphore returns the semaphore limiting concurrent copies into dir
+func (m *mirror) dirSemaphore(dir string, size int) chan struct{} {
+	m.dirSemsM.Lock()
+	defer m.dirSemsM.Unlock()
+	sem, ok := m.dirSems[dir]
+	if !ok {
+		sem = make(chan struct{}, size)
+		m.dirSems[dir] = sem
+	}
+	return sem
+}
+
+func (m *mirror) compareSourceWithDestination(cfg config.Config) (subs []config.Config, delDirs, delFiles []string, cpFiles []transfer) {
+	// symlinks to dirs are followed like dirs, unless they are skipped
+	follow := m.followLinks && !cfg.SkipDirLinks
+	sDirs, sFiles, err := readDir(m.fs, cfg.Source, false, follow)
 	if err != nil {
-		m.frontend.Fatal(fmt.Sprintf("Cannot read directory '%s': %s", cfg.Source, err))
+		if m.sourceGone() {
+			return nil, nil, nil, nil
+		}
+		m.scanFailed(cfg, fmt.Sprintf("Cannot read directory '%s': %s", cfg.Source, err))
+		return nil, nil, nil, nil
+	}
+	if follow {
+		for _, l := range dropLinkCycles(cfg.Source, sDirs) {
+			m.frontend.Progress(fmt.Sprintf("Skipping symlink %s, it points to a dir containing it", filepath.Join(cfg.Source, l)))
+			atomic.AddUint64(&m.dirLinksSkipped, 1)
+		}
+	}
+	relDir := relPath(m.srcRoot, cfg.Source, "")
+	dbg := newDebugListing(cfg.DebugListing, relDir, sDirs, sFiles)
+	defer dbg.print(cfg.Source, cfg.Destination)
+	if _, ok := sFiles[cfg.IgnoreFile]; ok && cfg.IgnoreFile != "" {
+		// the rules of deeper ignore files come first, so they override those of parent dirs
+		p := filepath.Join(cfg.Source, cfg.IgnoreFile)
+		rules, err := readIgnoreFile(m.fs, p, relDir)
+		if err != nil {
+			m.fail(fmt.Sprintf("Cannot read ignore file '%s': %s", p, err))
+			return nil, nil, nil, nil
+		}
+		cfg.Filters = append(rules, cfg.Filters...)
+	}
+	dropExcluded(cfg.Filters, relDir, sDirs, true)
+	dropExcluded(cfg.Filters, relDir, sFiles, false)
+	if cfg.MaxSymlinkDepth > 0 {
+		for name, e := range sFiles {
+			if e.Type()&fs.ModeSymlink == 0 {
+				continue
+			}
+			if _, err := resolveSymlink(m.fs, filepath.Join(cfg.Source, name), cfg.MaxSymlinkDepth); err != nil {
+				m.fail(fmt.Sprintf("Cannot resolve symlink: %s", err))
+				return nil, nil, nil, nil
+			}
+		}
+	}
+	if cfg.SkipDirLinks {
+		for _, l := range dropDirLinks(m.fs, cfg.Source, sFiles) {
+			m.frontend.Progress(fmt.Sprintf("Skipping symlink to dir %s", filepath.Join(cfg.Source, l)))
+			atomic.AddUint64(&m.dirLinksSkipped, 1)
+		}
+	}
+	m.frontend.Scanning(
+		atomic.AddUint64(&m.filesScanned, uint64(len(sFiles))),
+		atomic.AddUint64(&m.dirsScanned, uint64(len(sDirs))),
+	)
+	var dDirs, dFiles map[string]fs.DirEntry
+	if cfg.NoDestScan {
+		dDirs, dFiles = m.statEntries(cfg.Destination, sDirs, sFiles, cfg.Placeholder)
+	} else {
+		dDirs, dFiles, err = readDir(m.fs, cfg.Destination, true, false)
+	}
+	if (m.plan != nil || m.dryRun) && errors.Is(err, fs.ErrNotExist) {
+		// dir is only planned to be created
+		dDirs, dFiles, err = map[string]fs.DirEntry{}, map[string]fs.DirEntry{}, nil
 	}
-	dDirs, dFiles, err := readDir(cfg.Destination, true)
 	if err != nil {
-		m.frontend.Fatal(fmt.Sprintf("Cannot read directory '%s': %s", cfg.Destination, err))
+		m.scanFailed(cfg, fmt.Sprintf("Cannot read directory '%s': %s", cfg.Destination, err))
+		return nil, nil, nil, nil
+	}
+	dbg.destination(dDirs, dFiles)
+	if !cfg.Flatten && !cfg.DeleteExcluded {
+		// excluded destination entries are left alone
+		dropExcluded(cfg.Filters, relDir, dDirs, true)
+		dropExcluded(cfg.Filters, relDir, dFiles, false)
+	}
+	if cfg.SkipDirLinks {
+		dropDirLinks(m.fs, cfg.Destination, dFiles)
 	}
+	if cfg.IgnoreCase {
+		m.matchCase(cfg.Source, cfg.Destination, sDirs, sFiles, dDirs, dFiles)
+	}
+	m.dropBySize(cfg.Source, sFiles, dFiles)
 	subs = make([]config.Config, 0)
 	delDirs = make([]string, 0)
 	delFiles = make([]string, 0)
-	cpFiles = make([]string, 0)
+	cpFiles = make([]transfer, 0)
 	// determine source subs
 	for dirName, inf := range sDirs {
 		dDir := filepath.Join(cfg.Destination, dirName)
-		if _, exInDst := dDirs[dirName]; !exInDst {
+		_, exInDst := dDirs[dirName]
+		if exInDst && m.subtrees != nil && m.subtrees.unchanged(filepath.Join(cfg.Source, dirName)) {
+			atomic.AddUint64(&m.subtreesSkipped, 1)
+			dbg.decide(dirName, "skip unchanged subtree")
+			continue
+		}
+		dbg.decide(dirName, "descend")
+		if !exInDst && !cfg.Flatten {
 			if !m.allow(cfg.CreateDir, "Create dir '%s'", dDir) {
+				dbg.decide(dirName, "create dir declined")
+				m.frontend.Action("skip", dDir, 0)
 				continue
 			}
-			m.frontend.Progress(fmt.Sprintf("Creating dir %s", dDir))
-			os.Mkdir(dDir, inf.Type().Perm())
-			atomic.AddUint64(&m.dirsCreated, 1)
+			dbg.decide(dirName, "create dir")
+			if m.plan != nil {
+				m.record("mkdir", "", dDir)
+			} else {
+				m.frontend.Progress(fmt.Sprintf("Creating dir %s", dDir))
+				if !m.dryRun {
+					// a dir created meanwhile is fine, without the dir its subtree is skipped
+					if err := m.fs.Mkdir(dDir, dirPerm(inf)); err != nil && !errors.Is(err, fs.ErrExist) {
+						m.fail(fmt.Sprintf("Cannot create dir '%s': %s", dDir, err))
+						continue
+					}
+					if m.owner {
+						if sInf, err := inf.Info(); err == nil {
+							m.setOwner(sInf, dDir)
+						}
+					}
+				}
+				m.frontend.Action("mkdir", dDir, 0)
+			}
+			atomic.AddUint64(&m.stats.DirsCreated, 1)
+		}
+		if exInDst && cfg.Perms && !cfg.Flatten && !m.dryRun && m.alignPerm(filepath.Join(cfg.Source, dirName), dDir) {
+			atomic.AddUint64(&m.permsAligned, 1)
+		}
+		if m.maxDepth >= 0 && dirDepth(relDir) >= m.maxDepth {
+			dbg.decide(dirName, "not descending, -max-depth reached")
+			continue
 		}
 		subCfg := cfg
 		subCfg.Source = filepath.Join(cfg.Source, dirName)
-		subCfg.Destination = filepath.Join(cfg.Destination, dirName)
+		if !cfg.Flatten {
+			subCfg.Destination = filepath.Join(cfg.Destination, dirName)
+			subCfg.LinkDest = make([]string, len(cfg.LinkDest))
+			for i, ref := range cfg.LinkDest {
+				subCfg.LinkDest[i] = filepath.Join(ref, dirName)
+			}
+		}
 		subs = append(subs, subCfg)
 	}
 	// determine destination dirs to be deleted
 	for dst := range dDirs {
+		if cfg.Flatten {
+			// the flattened destination cannot tell orphans apart
+			break
+		}
 		if _, exInSrc := sDirs[dst]; !exInSrc {
 			if !m.allow(cfg.DeleteDir, "Delete dir '%s'", dst) {
+				dbg.decide(dst, "delete declined")
+				m.frontend.Action("skip", filepath.Join(cfg.Destination, dst), 0)
 				continue
 			}
+			dbg.decide(dst, "delete dir")
 			delDirs = append(delDirs, dst)
 		}
 	}
+	// keep empty dirs representable on destinations without directory support
+	if cfg.Placeholder != "" && !cfg.Flatten && m.plan == nil && !m.dryRun && len(sDirs) == 0 && len(sFiles) == 0 {
+		if _, exInDst := dFiles[cfg.Placeholder]; !exInDst {
+			p := filepath.Join(cfg.Destination, cfg.Placeholder)
+			m.frontend.Progress(fmt.Sprintf("Creating placeholder %s", p))
+			f, err := m.fs.Create(p)
+			if err == nil {
+				err = f.Close()
+			}
+			if err != nil {
+				m.fail(fmt.Sprintf("Cannot create placeholder '%s': %s", p, err))
+				return nil, nil, nil, nil
+			}
+		}
+	}
 	// determine destination files to be deleted
 	for dst := range dFiles {
+		if cfg.Flatten {
+			break
+		}
+		if dst == cfg.Placeholder {
+			continue
+		}
 		if _, exInSrc := sFiles[dst]; !exInSrc {
 			if !m.allow(cfg.DeleteFile, "Delete file '%s'", dst) {
+				dbg.decide(dst, "delete declined")
+				m.frontend.Action("skip", filepath.Join(cfg.Destination, dst), 0)
 				continue
 			}
+			dbg.decide(dst, "delete file")
 			delFiles = append(delFiles, dst)
 		}
 	}
 	// determine files to be copied
-	for fName := range sFiles {
+	for fName, e := range sFiles {
 		sPath := filepath.Join(cfg.Source, fName)
-		dPath := filepath.Join(cfg.Destination, fName)
-		if _, exInDst := dFiles[fName]; !exInDst {
+		link := !m.followLinks && e.Type()&fs.ModeSymlink != 0
+		if m.hardlinks != nil && !link {
+			m.checkHardlink(sPath)
+		}
+		dName := fName
+		if cfg.Flatten {
+			var ok bool
+			if dName, ok = m.flatName(sPath, fName, cfg.OnCollision); !ok {
+				dbg.decide(fName, "skip name collision")
+				continue
+			}
+		}
+		dPath := filepath.Join(cfg.Destination, dName)
+		if cfg.NoDestScan && dName != fName {
+			// renamed by flatten, so not looked up yet
+			m.statEntry(dPath, dName, map[string]fs.DirEntry{}, dFiles)
+		}
+		if _, exInDst := dFiles[dName]; !exInDst {
 			if !m.allow(cfg.CreateFile, "Create file '%s'", dPath) {
+				dbg.decide(fName, "copy declined")
+				m.frontend.Action("skip", dPath, 0)
+				continue
+			}
+			dbg.decide(fName, "copy, missing in destination")
+			cpFiles = append(cpFiles, transfer{src: fName, dst: dName, link: link, missing: true})
+		} else if m.entriesDiffer(sPath, dPath, e, dFiles[dName]) {
+			if cfg.Update && !m.sourceNewer(sPath, dPath) {
+				dbg.decide(fName, "skip, destination not older")
+				atomic.AddUint64(&m.skippedNewer, 1)
+				m.frontend.Action("skip", dPath, 0)
 				continue
 			}
-			cpFiles = append(cpFiles, fName)
-		} else if m.filesAreDifferent(sPath, dPath) {
 			if !m.allow(cfg.OverwriteFile, "Overwrite file '%s'", dPath) {
+				dbg.decide(fName, "overwrite declined")
+				m.frontend.Action("skip", dPath, 0)
 				continue
 			}
+			dbg.decide(fName, "overwrite, size or mtime differ")
+			cpFiles = append(cpFiles, transfer{src: fName, dst: dName, link: link})
 		} else {
-			atomic.AddUint64(&m.filesIdentical, 1)
+			dbg.decide(fName, "identical")
+			atomic.AddUint64(&m.stats.FilesIdentical, 1)
+			if !link {
+				m.addToManifest(dPath)
+			}
+			m.completed(sPath)
+			m.frontend.Action("identical", dPath, 0)
+			if cfg.AlignMetadata && !m.dryRun && !link && m.alignMetadata(sPath, dPath) {
+				atomic.AddUint64(&m.filesAligned, 1)
+			} else if cfg.Perms && !cfg.AlignMetadata && !m.dryRun && !link && m.alignPerm(sPath, dPath) {
+				atomic.AddUint64(&m.permsAligned, 1)
+			}
 		}
 	}
 	return subs, delDirs, delFiles, cpFiles
 }
 
+// dirDepth returns the depth of the dir at the slash separated path rel below the source, which is 0
+func dirDepth(rel string) int {
+	if rel == "." {
+		return 0
+	}
+	return strings.Count(rel, "/") + 1
+}
+
+// isStable reports whether the source file at path kept its size and mtime for the -stable interval.
+// A file last modified longer ago than the interval is stable without waiting.
+func (m *mirror) isStable(path string) bool {
+	before, err := m.fs.Stat(path)
+	if err != nil {
+		// left to the copy to report
+		return true
+	}
+	if time.Since(before.ModTime()) >= m.stable {
+		return true
+	}
+	time.Sleep(m.stable)
+	after, err := m.fs.Stat(path)
+	if err != nil {
+		return true
+	}
+	if after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+		return false
+	}
+	// the scan may have cached an older state
+	m.srcStats.invalidate(path)
+	return true
+}
+
+// sourceNewer reports whether the source file was modified after the destination file, beyond the tolerance
+func (m *mirror) sourceNewer(src, dst string) bool {
+	sInf, err := m.srcStats.stat(src)
+	if err != nil {
+		m.fail(fmt.Sprintf("Cannot get file info for '%s': %s", src, err))
+		return false
+	}
+	dInf, err := m.fs.Stat(dst)
+	if err != nil {
+		m.fail(fmt.Sprintf("Cannot get file info for '%s': %s", dst, err))
+		return false
+	}
+	return sInf.ModTime().Sub(dInf.ModTime()) > m.copyOpts.mtimeTolerance
+}
+
+// fail reports msg and stops the run, Run returns ErrFatal.
+// With -keep-going msg is collected instead and the run goes on, Run returns ErrFailures
+func (m *mirror) fail(msg string) {
+	if m.keepGoing {
+		m.frontend.Fatal(msg)
+		m.failuresM.Lock()
+		defer m.failuresM.Unlock()
+		m.failures = append(m.failures, msg)
+		return
+	}
+	m.frontend.Fatal(msg)
+	m.abort(fmt.Errorf("%w: %s", ErrFatal, msg))
+}
+
+// abort stops the run without starting new work, Run returns the first err
+func (m *mirror) abort(err error) {
+	m.failOnce.Do(func() { m.err = err })
+	m.failed.Store(true)
+}
+
+// sourceGone reports whether the source root is no longer accessible, e.g. after an unmount,
+// in which case the run stops without starting new work
+func (m *mirror) sourceGone() bool {
+	if m.srcGone.Load() {
+		return true
+	}
+	if _, err := m.fs.Stat(m.srcRoot); err == nil {
+		return false
+	}
+	m.srcGone.Store(true)
+	return true
+}
+
+// scanFailed aborts on a dir which cannot be read, or with cfg.CollectScanErrors records it to be reported at the end
+func (m *mirror) scanFailed(cfg config.Config, msg string) {
+	if !cfg.CollectScanErrors && !m.keepGoing {
+		m.fail(msg)
+		return
+	}
+	m.frontend.Progress(msg)
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.scanErrors = append(m.scanErrors, msg)
+}
+
+// flatName claims the destination name of a file in flatten mode, resolving collisions according to policy
+func (m *mirror) flatName(src, name, policy string) (string, bool) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if _, taken := m.flatNames[name]; !taken {
+		m.flatNames[name] = src
+		return name, true
+	}
+	switch policy {
+	case "skip":
+		m.frontend.Progress(fmt.Sprintf("Skipping %s, collides with %s", src, m.flatNames[name]))
+		return "", false
+	case "rename":
+		ext := filepath.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		for i := 1; ; i++ {
+			n := fmt.Sprintf("%s_%d%s", base, i, ext)
+			if _, taken := m.flatNames[n]; !taken {
+				m.flatNames[n] = src
+				return n, true
+			}
+		}
+	}
+	m.fail(fmt.Sprintf("Flattening '%s' collides with '%s'", src, m.flatNames[name]))
+	return "", false
+}
+
 func (m *mirror) filesAreDifferent(path1, path2 string) bool {
-	fi1, err := os.Stat(path1)
+	fi1, err := m.srcStats.stat(path1)
 	if err != nil {
-		m.frontend.Fatal(fmt.Sprintf("Cannot get file info for '%s': %s", path1, err))
+		m.fail(fmt.Sprintf("Cannot get file info for '%s': %s", path1, err))
+		return false
 	}
-	fi2, err := os.Stat(path2)
+	fi2, err := m.fs.Stat(path2)
 	if err != nil {
-		m.frontend.Fatal(fmt.Sprintf("Cannot get file info for '%s': %s", path2, err))
+		m.fail(fmt.Sprintf("Cannot get file info for '%s': %s", path2, err))
+		return false
+	}
+	// the cheap size check comes first, also in checksum mode
+	if fi1.Size() != fi2.Size() {
+		return true
+	}
+	if m.checksum {
+		same, err := m.equalContent(path1, path2, fi1.Size())
+		if err != nil {
+			m.fail(err.Error())
+			return false
+		}
+		return !same
+	}
+	if !m.copyOpts.preservesMtime() {
+		// the mtime of the destination says nothing about the source
+		return false
 	}
-	return fi1.Size() != fi2.Size() || fi1.ModTime().Sub(fi2.ModTime()) > time.Second
+	// a newer destination differs as well
+	return !m.copyOpts.sameMtime(fi1.ModTime(), fi2.ModTime())
+}
+
+// linkReference hard-links dst to the first file in the reference dirs which is identical to src
+func (m *mirror) linkReference(refs []string, name, src, dst string) bool {
+	if len(refs) == 0 {
+		return false
+	}
+	srcInf, err := m.srcStats.stat(src)
+	if err != nil {
+		m.fail(fmt.Sprintf("Cannot get file info for '%s': %s", src, err))
+		return false
+	}
+	var srcHash []byte
+	for _, ref := range refs {
+		r := filepath.Join(ref, name)
+		refInf, err := m.fs.Stat(r)
+		if err != nil || !refInf.Mode().IsRegular() || refInf.Size() != srcInf.Size() {
+			continue
+		}
+		if m.mmapCompare {
+			if same, err := m.equalContent(src, r, srcInf.Size()); err != nil || !same {
+				continue
+			}
+		} else {
+			// hash the source only once for all reference dirs
+			if srcHash == nil {
+				if srcHash, err = hashFile(m.fs, src); err != nil {
+					m.fail(err.Error())
+					return false
+				}
+			}
+			refHash, err := hashFile(m.fs, r)
+			if err != nil || !bytes.Equal(srcHash, refHash) {
+				continue
+			}
+		}
+		if err := m.fs.Link(r, dst); err != nil {
+			m.frontend.Progress(fmt.Sprintf("Cannot link %s to %s: %s", r, dst, err))
+			return false
+		}
+		m.frontend.Progress(fmt.Sprintf("Link %s to %s", r, dst))
+		return true
+	}
+	return false
 }
 
 func (m *mirror) allow(flagPtr *rune, msg string, msgVals ...interface{}) bool {
@@ -211,25 +1221,34 @@ func (m *mirror) allow(flagPtr *rune, msg string, msgVals ...interface{}) bool {
 	if *flagPtr == 'x' {
 		return false
 	}
-	switch m.frontend.Choice(fmt.Sprintf(msg+" (y=yes,n=no,a=all,x=none,q=quit)", msgVals...), "ynaq") {
+	// nothing more is asked once the run is stopped, e.g. after q
+	if m.failed.Load() {
+		return false
+	}
+	switch m.frontend.Choice(fmt.Sprintf(msg+" (y=yes,n=no,a=all,x=none,q=quit)", msgVals...), "ynaxq") {
 	case 'y':
 		return true
 	case 'n':
+		m.declined = true
 		return false
 	case 'a':
 		*flagPtr = 'a'
 		return true
 	case 'x':
 		*flagPtr = 'x'
+		m.declined = true
 		return false
 	case 'q':
-		os.Exit(1)
+		m.abort(ErrQuit)
+		return false
 	}
 	panic("choice")
 }
 
-func readDir(path string, create bool) (dirs map[string]fs.DirEntry, files map[string]fs.DirEntry, err error) {
-	ee, err := os.ReadDir(path)
+// readDir returns the dirs and files of the dir at path. With follow, symlinks to dirs are among the dirs,
+// with the entry of their target
+func readDir(fsys FS, path string, create, follow bool) (dirs map[string]fs.DirEntry, files map[string]fs.DirEntry, err error) {
+	ee, err := fsys.ReadDir(path)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -238,39 +1257,127 @@ func readDir(path string, create bool) (dirs map[string]fs.DirEntry, files map[s
 	for _, e := range ee {
 		if e.IsDir() {
 			dirs[e.Name()] = e
-		} else {
-			files[e.Name()] = e
+			continue
 		}
+		if follow && e.Type()&fs.ModeSymlink != 0 {
+			if inf, err := fsys.Stat(filepath.Join(path, e.Name())); err == nil && inf.IsDir() {
+				dirs[e.Name()] = fs.FileInfoToDirEntry(inf)
+				continue
+			}
+		}
+		files[e.Name()] = e
 	}
 	return dirs, files, nil
 }
 
-func copyFile(src, dst string) error {
-	copy := func() error {
-		srcF, err := os.Open(src)
-		if err != nil {
-			return fmt.Errorf("Could not open '%s' for reading", src)
+// dropLinkCycles removes the followed symlinks among the dirs of dir which point to dir or one of its parents,
+// as they would be descended into endlessly, and returns their names
+func dropLinkCycles(dir string, dirs map[string]fs.DirEntry) []string {
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil
+	}
+	var links []string
+	for name := range dirs {
+		target, err := filepath.EvalSymlinks(filepath.Join(dir, name))
+		if err != nil || target == filepath.Join(real, name) {
+			continue
 		}
-		defer srcF.Close()
-		dstF, err := os.Create(dst)
-		if err != nil {
-			return fmt.Errorf("Could not create '%s' for writing", dst)
+		if rel, err := filepath.Rel(target, real); err == nil && (rel == "." || filepath.IsLocal(rel)) {
+			delete(dirs, name)
+			links = append(links, name)
 		}
-		defer dstF.Close()
-		if _, err := io.Copy(dstF, srcF); err != nil {
-			return fmt.Errorf("error copying file '%s': %s", src, err)
+	}
+	return links
+}
+
+// statEntries looks up the names of the given entries in dir instead of reading the whole dir
+func (m *mirror) statEntries(dir string, dirEntries, fileEntries map[string]fs.DirEntry, extra ...string) (dirs, files map[string]fs.DirEntry) {
+	dirs = make(map[string]fs.DirEntry)
+	files = make(map[string]fs.DirEntry)
+	for _, entries := range []map[string]fs.DirEntry{dirEntries, fileEntries} {
+		for name := range entries {
+			m.statEntry(filepath.Join(dir, name), name, dirs, files)
 		}
-		return nil
 	}
-	if err := copy(); err != nil {
-		return err
+	for _, name := range extra {
+		if name != "" {
+			m.statEntry(filepath.Join(dir, name), name, dirs, files)
+		}
+	}
+	return dirs, files
+}
+
+// statEntry adds path to dirs or files if it exists
+func (m *mirror) statEntry(path, name string, dirs, files map[string]fs.DirEntry) {
+	inf, err := m.fs.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		m.fail(fmt.Sprintf("Cannot get file info for '%s': %s", path, err))
+		return
+	}
+	if inf.IsDir() {
+		dirs[name] = fs.FileInfoToDirEntry(inf)
+	} else {
+		files[name] = fs.FileInfoToDirEntry(inf)
 	}
-	inf, err := os.Stat(src)
+}
+
+// hashFile returns the sha256 of the file at path, computed by fsys if it is a ChecksumFS
+func hashFile(fsys FS, path string) ([]byte, error) {
+	if c, ok := fsys.(ChecksumFS); ok {
+		return c.Checksum(path, "sha256")
+	}
+	f, err := fsys.Open(path)
 	if err != nil {
-		return fmt.Errorf("get file info for '%s': %w", src, err)
+		return nil, fmt.Errorf("Could not open '%s' for reading", path)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("error hashing file '%s': %s", path, err)
+	}
+	return h.Sum(nil), nil
+}
+
+// dropDirLinks removes symlinks pointing to directories from the files of dir and returns their names
+func dropDirLinks(fsys FS, dir string, files map[string]fs.DirEntry) []string {
+	var links []string
+	for name, e := range files {
+		if e.Type()&fs.ModeSymlink == 0 {
+			continue
+		}
+		if inf, err := fsys.Stat(filepath.Join(dir, name)); err == nil && inf.IsDir() {
+			delete(files, name)
+			links = append(links, name)
+		}
 	}
-	if err := os.Chtimes(dst, inf.ModTime(), inf.ModTime()); err != nil {
-		return fmt.Errorf("set modification time for '%s': %w", dst, err)
+	return links
+}
+
+// resolveSymlink follows the chain of symlinks at path for at most maxDepth links and returns the final target
+func resolveSymlink(fsys FS, path string, maxDepth int) (string, error) {
+	link := path
+	for depth := 0; ; depth++ {
+		inf, err := fsys.Lstat(path)
+		if err != nil {
+			return "", err
+		}
+		if inf.Mode()&fs.ModeSymlink == 0 {
+			return path, nil
+		}
+		if depth == maxDepth {
+			return "", fmt.Errorf("'%s': %w", link, errTooManyLinks)
+		}
+		target, err := fsys.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
 	}
-	return nil
 }
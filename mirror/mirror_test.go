@@ -0,0 +1,728 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/binChris/mirror/config"
+)
+
+// testFrontend records the actions and errors of a run and answers every choice with answer
+type testFrontend struct {
+	m        sync.Mutex
+	answer   rune
+	actions  []string
+	fatal    []string
+	progress []string
+	files    int // totals set for the progress percentage
+	asked    int
+	options  string // of the last choice
+}
+
+func (f *testFrontend) Progress(msg string) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.progress = append(f.progress, msg)
+}
+
+func (f *testFrontend) Scanning(files, dirs uint64)      {}
+func (f *testFrontend) SetTotals(files int, bytes int64) { f.files = files }
+func (f *testFrontend) Completed(files int, bytes int64) {}
+
+func (f *testFrontend) Fatal(msg string) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.fatal = append(f.fatal, msg)
+}
+
+func (f *testFrontend) Choice(msg string, options string) rune {
+	f.m.Lock()
+	f.asked++
+	f.options = options
+	f.m.Unlock()
+	if f.answer == 0 {
+		return 'n'
+	}
+	return f.answer
+}
+
+func (f *testFrontend) Action(action, path string, bytes int64) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.actions = append(f.actions, action+" "+path)
+}
+
+// sortedActions returns the recorded actions in a stable order
+func (f *testFrontend) sortedActions() []string {
+	f.m.Lock()
+	defer f.m.Unlock()
+	a := append([]string(nil), f.actions...)
+	sort.Strings(a)
+	return a
+}
+
+// testConfig returns the configuration of FromCommandLine with -force for mirroring src to dst
+func testConfig(src, dst string) config.Config {
+	answer := func(r rune) *rune { return &r }
+	return config.Config{
+		Source:           src,
+		Destination:      dst,
+		CreateDir:        answer('a'),
+		DeleteDir:        answer('a'),
+		CreateFile:       answer('a'),
+		OverwriteFile:    answer('a'),
+		DeleteFile:       answer('a'),
+		OnCollision:      "error",
+		Umask:            -1,
+		LogMaxFiles:      5,
+		ListFormat:       "status,size,mtime,path",
+		ListStyle:        "tsv",
+		CopyMethod:       "auto",
+		SparseMinHole:    4 << 10,
+		Verify:           "none",
+		PercentBasis:     "bytes",
+		DeleteParallel:   4,
+		MmapMinSize:      64 << 20,
+		CopyOrder:        "any",
+		Mtime:            "preserve",
+		ScanParallel:     4,
+		BlockSyncSize:    128 << 10,
+		FileParallel:     1,
+		FileParallelMin:  1 << 30,
+		BufferSize:       32 << 10,
+		MtimeTolerance:   time.Second,
+		MaxDepth:         -1,
+		ProgressInterval: time.Second,
+		IgnoreFile:       ".mirrorignore",
+		RetryDelay:       time.Second,
+	}
+}
+
+// runTest mirrors with cfg and fails the test on an error of the run
+func runTest(t *testing.T, cfg config.Config) (Stats, *testFrontend) {
+	t.Helper()
+	f := &testFrontend{}
+	stats, err := Run(context.Background(), cfg, 2, f)
+	if err != nil {
+		t.Fatalf("Run() error = %v, fatal %v", err, f.fatal)
+	}
+	return stats, f
+}
+
+// stringsEqual reports whether a and b hold the same strings in the same order
+func stringsEqual(a, b []string) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// cancelFrontend cancels the run when the first file was copied
+type cancelFrontend struct {
+	*testFrontend
+	cancel context.CancelFunc
+}
+
+func (f *cancelFrontend) Action(action, path string, bytes int64) {
+	f.testFrontend.Action(action, path, bytes)
+	if action == "copy" {
+		f.cancel()
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	fsys := newMemFS()
+	mtime := time.Now()
+	for i := 0; i < 20; i++ {
+		fsys.file(fmt.Sprintf("/s/%02d", i), "content", mtime)
+	}
+	if err := fsys.Mkdir("/d", 0755); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := &cancelFrontend{testFrontend: &testFrontend{}, cancel: cancel}
+	type result struct {
+		stats Stats
+		err   error
+	}
+	done := make(chan result)
+	go func() {
+		stats, err := RunFS(ctx, testConfig("/s", "/d"), 1, f, fsys)
+		done <- result{stats, err}
+	}()
+	var r result
+	select {
+	case r = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run() did not stop after cancellation")
+	}
+	if !errors.Is(r.err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", r.err, context.Canceled)
+	}
+	// only the copy that cancelled the run was made
+	if r.stats.FilesCopied != 1 {
+		t.Errorf("%d files copied, want 1", r.stats.FilesCopied)
+	}
+	if got := fsys.tree("/d"); len(got) != 1 {
+		t.Errorf("destination = %v, want 1 file", got)
+	}
+}
+
+func TestRunCancelledBeforeStart(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeFile(t, src, "a", "a")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stats, err := Run(ctx, testConfig(src, dst), 2, &testFrontend{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	if stats.FilesCopied != 0 {
+		t.Errorf("%d files copied after cancellation", stats.FilesCopied)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	tests := []struct {
+		name        string
+		flag        rune
+		answer      rune
+		want        bool
+		wantFlag    rune
+		wantAsked   int // by two calls
+		wantDecline bool
+	}{
+		{name: "yes", flag: '-', answer: 'y', want: true, wantFlag: '-', wantAsked: 2},
+		{name: "no", flag: '-', answer: 'n', wantFlag: '-', wantAsked: 2, wantDecline: true},
+		{name: "all", flag: '-', answer: 'a', want: true, wantFlag: 'a', wantAsked: 1},
+		{name: "none", flag: '-', answer: 'x', wantFlag: 'x', wantAsked: 1, wantDecline: true},
+		{name: "quit", flag: '-', answer: 'q', wantFlag: '-', wantAsked: 1},
+		{name: "forced", flag: 'a', want: true, wantFlag: 'a'},
+		{name: "never", flag: 'x', wantFlag: 'x'},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &testFrontend{answer: tt.answer}
+			m := &mirror{frontend: f}
+			flag := tt.flag
+			for i := 0; i < 2; i++ {
+				if got := m.allow(&flag, "Copy '%s'", "a"); got != tt.want {
+					t.Errorf("call %d: allow() = %v, want %v", i+1, got, tt.want)
+				}
+			}
+			if flag != tt.wantFlag || f.asked != tt.wantAsked || m.declined != tt.wantDecline {
+				t.Errorf("flag %c, asked %d times, declined %v, want %c, %d, %v", flag, f.asked, m.declined, tt.wantFlag, tt.wantAsked, tt.wantDecline)
+			}
+			if f.asked > 0 && f.options != "ynaxq" {
+				t.Errorf("options = %q, want ynaxq", f.options)
+			}
+		})
+	}
+}
+
+func TestQuit(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	for i := 0; i < 6; i++ {
+		writeFile(t, dst, fmt.Sprint("orphan", i), "orphan")
+	}
+	cfg := testConfig(src, dst)
+	ask := '-'
+	cfg.DeleteFile = &ask
+	f := &testFrontend{answer: 'q'}
+	if _, err := Run(context.Background(), cfg, 2, f); !errors.Is(err, ErrQuit) {
+		t.Fatalf("Run() error = %v, want %v", err, ErrQuit)
+	}
+	if f.asked != 1 {
+		t.Errorf("asked %d times, want once", f.asked)
+	}
+	if got := treeFiles(t, dst); len(got) != 6 {
+		t.Errorf("destination %v, want all orphans kept", got)
+	}
+}
+
+func TestKeepGoing(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		keepGoing bool
+		want      error
+	}{
+		{name: "fatal", want: ErrFatal},
+		{name: "keep going", keepGoing: true, want: ErrFailures},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := &deniedFS{memFS: newMemFS(), denied: map[string]bool{"/s/b": true}}
+			for _, name := range []string{"a", "b", "c", "d"} {
+				fsys.file("/s/"+name, name, mtime)
+			}
+			fsys.file("/d/.keep", "", mtime) // creates /d, deleted by the run
+			cfg := testConfig("/s", "/d")
+			cfg.KeepGoing = tt.keepGoing
+			f := &testFrontend{}
+			_, err := RunFS(context.Background(), cfg, 1, f, fsys)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("RunFS() error = %v, want %v", err, tt.want)
+			}
+			if len(f.fatal) != 1 || !strings.Contains(f.fatal[0], "/s/b") {
+				t.Errorf("reported %q, want the error of /s/b", f.fatal)
+			}
+			if !tt.keepGoing {
+				return
+			}
+			want := map[string]string{"a": "a", "c": "c", "d": "d"}
+			if got := fsys.tree("/d"); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("destination = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name   string
+		update bool
+		offset time.Duration // of the source mtime
+		want   string
+	}{
+		{name: "source newer", update: true, offset: time.Hour, want: "overwrite"},
+		{name: "destination newer", update: true, offset: -time.Hour, want: "skip"},
+		{name: "equal", update: true, want: "skip"},
+		{name: "destination newer without -update", offset: -time.Hour, want: "overwrite"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the sizes differ, so the files differ at equal mtimes as well
+			fsys := newMemFS()
+			fsys.file("/s/f", "source", mtime.Add(tt.offset))
+			fsys.file("/d/f", "edited destination", mtime)
+			cfg := testConfig("/s", "/d")
+			cfg.Update = tt.update
+			f := &testFrontend{}
+			if _, err := RunFS(context.Background(), cfg, 1, f, fsys); err != nil {
+				t.Fatalf("RunFS() error = %v, fatal %v", err, f.fatal)
+			}
+			if got := f.sortedActions(); !stringsEqual(got, []string{tt.want + " /d/f"}) {
+				t.Errorf("actions = %v, want %s", got, tt.want)
+			}
+			want := "edited destination"
+			if tt.want == "overwrite" {
+				want = "source"
+			}
+			if got := fsys.tree("/d")["f"]; got != want {
+				t.Errorf("destination f = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDeepTree(t *testing.T) {
+	tests := []struct {
+		name         string
+		scanParallel int
+		depth, width int
+	}{
+		{name: "deep", scanParallel: 4, depth: 40, width: 1},
+		{name: "wide and deep", scanParallel: 4, depth: 6, width: 3},
+		{name: "serial scan", scanParallel: 1, depth: 6, width: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			var want []string
+			var fill func(dir string, depth int)
+			fill = func(dir string, depth int) {
+				want = append(want, path.Join(dir, "f"))
+				writeFile(t, src, path.Join(dir, "f"), dir)
+				if depth == 0 {
+					return
+				}
+				for i := 0; i < tt.width; i++ {
+					fill(path.Join(dir, fmt.Sprint(i)), depth-1)
+				}
+			}
+			fill(".", tt.depth)
+			sort.Strings(want)
+			cfg := testConfig(src, dst)
+			cfg.ScanParallel = tt.scanParallel
+			f := &testFrontend{}
+			done := make(chan error)
+			go func() {
+				_, err := Run(context.Background(), cfg, 2, f)
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("Run() error = %v, fatal %v", err, f.fatal)
+				}
+			case <-time.After(time.Minute):
+				t.Fatal("Run() did not finish")
+			}
+			if got := treeFiles(t, dst); !stringsEqual(got, want) {
+				t.Errorf("mirrored %d files, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+// scanFrontend releases the copies of fsys once want dirs were scanned while they were blocked
+type scanFrontend struct {
+	*testFrontend
+	fsys    *blockingFS
+	want    int
+	scanned int // dirs scanned during the copies
+	once    sync.Once
+}
+
+func (f *scanFrontend) Scanning(files, dirs uint64) {
+	select {
+	case <-f.fsys.copying:
+	default:
+		return
+	}
+	f.m.Lock()
+	f.scanned++
+	done := f.scanned == f.want
+	f.m.Unlock()
+	if done {
+		f.releaseCopies()
+	}
+}
+
+func (f *scanFrontend) releaseCopies() {
+	f.once.Do(func() { close(f.fsys.release) })
+}
+
+func TestScanDuringCopy(t *testing.T) {
+	fsys := newBlockingFS("/s")
+	mtime := time.Now()
+	fsys.file("/s/big", "big", mtime)
+	for _, d := range []string{"a", "b", "c"} {
+		fsys.file(path.Join("/s", d, "f"), d, mtime)
+	}
+	if err := fsys.Mkdir("/d", 0755); err != nil {
+		t.Fatal(err)
+	}
+	f := &scanFrontend{testFrontend: &testFrontend{}, fsys: fsys, want: 3}
+	// a run scanning only after copying would otherwise never finish
+	timer := time.AfterFunc(10*time.Second, f.releaseCopies)
+	defer timer.Stop()
+	if _, err := RunFS(context.Background(), testConfig("/s", "/d"), 2, f, fsys); err != nil {
+		t.Fatalf("RunFS() error = %v, fatal %v", err, f.fatal)
+	}
+	if fsys.timedOut.Load() || f.scanned != f.want {
+		t.Errorf("%d dirs scanned while copying, want %d", f.scanned, f.want)
+	}
+	want := map[string]string{"big": "big", "a/": "", "a/f": "a", "b/": "", "b/f": "b", "c/": "", "c/f": "c"}
+	if got := fsys.tree("/d"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("destination = %v, want %v", got, want)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	src := t.TempDir()
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for name, content := range map[string]string{"new": "new", "same": "same", "sub/changed": "changed", "sub/dir/f": "f"} {
+		writeFile(t, src, name, content)
+	}
+	if err := os.Chtimes(filepath.Join(src, "same"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	// two identical destinations with a stale and extra files and dirs, one mirrored dry, one for real
+	var dsts [2]string
+	for i := range dsts {
+		dsts[i] = t.TempDir()
+		for name, content := range map[string]string{"same": "same", "sub/changed": "stale", "extra": "x", "old/f": "o"} {
+			writeFile(t, dsts[i], name, content)
+		}
+		if err := os.Chtimes(filepath.Join(dsts[i], "same"), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := snapshot(t, dsts[0])
+	cfg := testConfig(src, dsts[0])
+	cfg.DryRun = true
+	dryStats, dry := runTest(t, cfg)
+	if after := snapshot(t, dsts[0]); !stringsEqual(after, before) {
+		t.Errorf("dry run changed the destination to %v, was %v", after, before)
+	}
+	stats, real := runTest(t, testConfig(src, dsts[1]))
+	dryStats.Duration, stats.Duration = 0, 0
+	if dryStats != stats {
+		t.Errorf("dry run stats = %+v, want %+v", dryStats, stats)
+	}
+	// the actions without the destination dir
+	relative := func(f *testFrontend, dst string) []string {
+		a := f.sortedActions()
+		for i := range a {
+			a[i] = strings.Replace(a[i], dst, "", 1)
+		}
+		return a
+	}
+	if got, want := relative(dry, dsts[0]), relative(real, dsts[1]); !stringsEqual(got, want) {
+		t.Errorf("dry run actions = %v, want %v", got, want)
+	}
+}
+
+// snapshot returns the entries below root with their mode, modification time and content
+func snapshot(t *testing.T, root string) []string {
+	t.Helper()
+	var entries []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		inf, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		e := fmt.Sprintf("%s %v %v", filepath.ToSlash(rel), inf.Mode(), inf.ModTime())
+		if !d.IsDir() {
+			e += " " + readFile(t, p)
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestReadOnlyDestinationParent(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		keepGoing bool
+		want      error
+	}{
+		{name: "fatal", want: ErrFatal},
+		{name: "keep going", keepGoing: true, want: ErrFailures},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := &readOnlyFS{memFS: newMemFS(), ro: "/d/ro"}
+			fsys.file("/s/ro/new/f", "f", mtime)
+			fsys.file("/s/ok/g", "g", mtime)
+			fsys.file("/d/ro/.keep", "", mtime) // creates /d/ro
+			cfg := testConfig("/s", "/d")
+			cfg.KeepGoing = tt.keepGoing
+			no := 'x'
+			cfg.DeleteFile = &no
+			f := &testFrontend{}
+			if _, err := RunFS(context.Background(), cfg, 1, f, fsys); !errors.Is(err, tt.want) {
+				t.Fatalf("RunFS() error = %v, want %v", err, tt.want)
+			}
+			// the dir is reported, not the copy of the file below it
+			if len(f.fatal) != 1 || !strings.Contains(f.fatal[0], "Cannot create dir '/d/ro/new'") || !strings.Contains(f.fatal[0], "permission denied") {
+				t.Errorf("reported %q, want the failed dir", f.fatal)
+			}
+			if !tt.keepGoing {
+				return
+			}
+			if got := fsys.tree("/d")["ok/g"]; got != "g" {
+				t.Errorf("ok/g = %q, want it copied", got)
+			}
+		})
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxDepth int
+		want     []string
+		wantDir  string // created, but not mirrored
+	}{
+		{name: "0", maxDepth: 0, want: []string{"f0"}, wantDir: "a"},
+		{name: "1", maxDepth: 1, want: []string{"a/f1", "f0"}, wantDir: "a/b"},
+		{name: "unlimited", maxDepth: -1, want: []string{"a/b/c/f3", "a/b/f2", "a/f1", "f0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			for _, p := range []string{"f0", "a/f1", "a/b/f2", "a/b/c/f3"} {
+				writeFile(t, src, p, p)
+			}
+			cfg := testConfig(src, dst)
+			cfg.MaxDepth = tt.maxDepth
+			runTest(t, cfg)
+			if got := treeFiles(t, dst); !stringsEqual(got, tt.want) {
+				t.Errorf("mirrored %v, want %v", got, tt.want)
+			}
+			if tt.wantDir == "" {
+				return
+			}
+			if inf, err := os.Stat(filepath.Join(dst, tt.wantDir)); err != nil || !inf.IsDir() {
+				t.Errorf("dir %s not created: %v", tt.wantDir, err)
+			}
+		})
+	}
+}
+
+func TestOverwriteChanged(t *testing.T) {
+	tests := []struct {
+		name   string
+		dst    string
+		answer rune // to the overwrite prompt, 0 for -force
+		want   string
+	}{
+		{name: "longer destination", dst: "old and longer", want: "new"},
+		{name: "shorter destination", dst: "o", want: "new"},
+		{name: "same size", dst: "old", want: "new"},
+		{name: "overwrite allowed", dst: "old content", answer: 'y', want: "new"},
+		{name: "overwrite declined", dst: "old content", answer: 'n', want: "old content"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			writeFile(t, src, "f", "new")
+			p := writeFile(t, dst, "f", tt.dst)
+			// older than the source, so an equal size is not taken as identical
+			if err := os.Chtimes(p, time.Unix(0, 0), time.Unix(0, 0)); err != nil {
+				t.Fatal(err)
+			}
+			cfg := testConfig(src, dst)
+			if tt.answer != 0 {
+				ask := '-'
+				cfg.OverwriteFile = &ask
+			}
+			f := &testFrontend{answer: tt.answer}
+			if _, err := Run(context.Background(), cfg, 2, f); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if got := readFile(t, p); got != tt.want {
+				t.Errorf("destination = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// captureStdout returns what fn prints to stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- b
+	}()
+	defer func() { os.Stdout = stdout }()
+	fn()
+	w.Close()
+	return string(<-done)
+}
+
+// benchmarkRun mirrors src to a fresh copy of the destination made by newDst in each iteration
+func benchmarkRun(b *testing.B, cfg config.Config, parallel int, newDst func(dst string)) {
+	b.Helper()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		if err := os.RemoveAll(cfg.Destination); err != nil {
+			b.Fatal(err)
+		}
+		if err := os.Mkdir(cfg.Destination, 0755); err != nil {
+			b.Fatal(err)
+		}
+		if newDst != nil {
+			newDst(cfg.Destination)
+		}
+		b.StartTimer()
+		stdout := os.Stdout
+		os.Stdout, _ = os.Open(os.DevNull)
+		_, err := Run(context.Background(), cfg, parallel, &testFrontend{})
+		os.Stdout.Close()
+		os.Stdout = stdout
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchFiles creates n files of size bytes in dir
+func benchFiles(b *testing.B, dir string, n, size int) {
+	b.Helper()
+	data := make([]byte, size)
+	for i := 0; i < n; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%05d", i)), data, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkPerDirConcurrency copies a single dir of many files with different limits of concurrent copies into it
+func BenchmarkPerDirConcurrency(b *testing.B) {
+	src := b.TempDir()
+	benchFiles(b, src, 500, 4<<10)
+	for _, limit := range []int{0, 1, 2, 4} {
+		b.Run(fmt.Sprintf("limit %d", limit), func(b *testing.B) {
+			cfg := testConfig(src, filepath.Join(b.TempDir(), "dst"))
+			cfg.PerDirConcurrency = limit
+			benchmarkRun(b, cfg, 8, nil)
+		})
+	}
+}
+
+// BenchmarkCopyOrder copies a dir whose files were written in an order other than their names
+func BenchmarkCopyOrder(b *testing.B) {
+	src := b.TempDir()
+	data := make([]byte, 64<<10)
+	for i := 0; i < 500; i++ {
+		// interleaves the inode numbers with the name order
+		name := fmt.Sprintf("f%05d", (i*7919)%500)
+		if err := os.WriteFile(filepath.Join(src, name), data, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for _, order := range []string{"any", "locality"} {
+		b.Run(order, func(b *testing.B) {
+			cfg := testConfig(src, filepath.Join(b.TempDir(), "dst"))
+			cfg.CopyOrder = order
+			benchmarkRun(b, cfg, 1, nil)
+		})
+	}
+}
+
+// BenchmarkNoDestScan copies a few files into a destination of many unrelated files
+func BenchmarkNoDestScan(b *testing.B) {
+	src, unrelated := b.TempDir(), b.TempDir()
+	benchFiles(b, src, 10, 1<<10)
+	benchFiles(b, unrelated, 20000, 0)
+	ee, err := os.ReadDir(unrelated)
+	if err != nil {
+		b.Fatal(err)
+	}
+	newDst := func(dst string) {
+		for _, e := range ee {
+			if err := os.Link(filepath.Join(unrelated, e.Name()), filepath.Join(dst, "u"+e.Name())); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	for _, noScan := range []bool{false, true} {
+		b.Run(fmt.Sprintf("no-dest-scan %v", noScan), func(b *testing.B) {
+			cfg := testConfig(src, filepath.Join(b.TempDir(), "dst"))
+			cfg.NoDelete, cfg.NoDestScan = true, noScan
+			no := 'x'
+			cfg.DeleteDir, cfg.DeleteFile = &no, &no
+			benchmarkRun(b, cfg, 4, newDst)
+		})
+	}
+}
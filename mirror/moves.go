@@ -0,0 +1,154 @@
+package mirror
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/binChris/mirror/config"
+)
+
+// moves holds the deletes and the copies of new files of -detect-moves until all dirs are compared
+type moves struct {
+	m       sync.Mutex
+	deletes []pendingDeletes
+	copies  []pendingCopies
+}
+
+type pendingDeletes struct {
+	cfg         config.Config
+	dirs, files []string
+}
+
+type pendingCopies struct {
+	cfg   config.Config
+	files []transfer
+}
+
+func newMoves() *moves {
+	return &moves{}
+}
+
+// hold keeps the deletes and the copies of new files of the dir of cfg and returns the copies which can start now
+func (mv *moves) hold(cfg config.Config, delDirs, delFiles []string, cpFiles []transfer) []transfer {
+	var now, later []transfer
+	for _, cp := range cpFiles {
+		if cp.missing && !cp.link {
+			later = append(later, cp)
+		} else {
+			now = append(now, cp)
+		}
+	}
+	mv.m.Lock()
+	defer mv.m.Unlock()
+	if len(delDirs)+len(delFiles) > 0 {
+		mv.deletes = append(mv.deletes, pendingDeletes{cfg, delDirs, delFiles})
+	}
+	if len(later) > 0 {
+		mv.copies = append(mv.copies, pendingCopies{cfg, later})
+	}
+	return now
+}
+
+// applyMoves renames destination files to be deleted into the place of new files with the same content,
+// then starts the remaining copies and the deletes held back
+func (m *mirror) applyMoves() {
+	// destination files to be deleted, also those in dirs to be deleted, by size
+	bySize := make(map[int64][]string)
+	index := func(path string, inf fs.FileInfo) {
+		if inf.Mode().IsRegular() && inf.Size() > 0 {
+			bySize[inf.Size()] = append(bySize[inf.Size()], path)
+		}
+	}
+	for _, p := range m.moves.deletes {
+		for _, f := range p.files {
+			path := filepath.Join(p.cfg.Destination, f)
+			if inf, err := m.fs.Lstat(path); err == nil {
+				index(path, inf)
+			}
+		}
+		for _, d := range p.dirs {
+			filepath.WalkDir(filepath.Join(p.cfg.Destination, d), func(path string, e fs.DirEntry, err error) error {
+				if err == nil && e.Type().IsRegular() {
+					if inf, err := e.Info(); err == nil {
+						index(path, inf)
+					}
+				}
+				return nil
+			})
+		}
+	}
+	hashes := make(map[string][]byte)
+	moved := make(map[string]bool)
+	for _, p := range m.moves.copies {
+		var copies []transfer
+		for _, cp := range p.files {
+			s := filepath.Join(p.cfg.Source, cp.src)
+			d := filepath.Join(p.cfg.Destination, cp.dst)
+			if from, ok := m.movedFile(s, bySize, hashes, moved); ok && m.move(from, s, d) {
+				moved[from] = true
+				continue
+			}
+			copies = append(copies, cp)
+		}
+		m.startCopies(p.cfg, copies)
+	}
+	for _, p := range m.moves.deletes {
+		var files []string
+		for _, f := range p.files {
+			if !moved[filepath.Join(p.cfg.Destination, f)] {
+				files = append(files, f)
+			}
+		}
+		m.startDeletes(p.cfg, p.dirs, files)
+	}
+}
+
+// movedFile returns a destination file to be deleted with the size and content of the source file src
+func (m *mirror) movedFile(src string, bySize map[int64][]string, hashes map[string][]byte, moved map[string]bool) (string, bool) {
+	inf, err := m.srcStats.stat(src)
+	if err != nil {
+		return "", false
+	}
+	var srcHash []byte
+	for _, path := range bySize[inf.Size()] {
+		if moved[path] {
+			continue
+		}
+		if srcHash == nil {
+			if srcHash, err = hashFile(m.fs, src); err != nil {
+				return "", false
+			}
+		}
+		h, ok := hashes[path]
+		if !ok {
+			// unreadable files don't match
+			h, _ = hashFile(m.fs, path)
+			hashes[path] = h
+		}
+		if bytes.Equal(h, srcHash) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// move renames the destination file from to dst, the place of the source file src, and reports whether it succeeded
+func (m *mirror) move(from, src, dst string) bool {
+	m.frontend.Progress(fmt.Sprintf("Move %s to %s", from, dst))
+	if !m.dryRun {
+		if err := m.fs.Rename(from, dst); err != nil {
+			m.frontend.Progress(fmt.Sprintf("Warning: cannot move '%s', copying instead: %s", from, err))
+			return false
+		}
+		m.alignMetadata(src, dst)
+		m.addToManifest(dst)
+	}
+	atomic.AddUint64(&m.filesMoved, 1)
+	m.completed(src)
+	m.frontend.Action("move", dst, 0)
+	return true
+}
@@ -0,0 +1,58 @@
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectMoves(t *testing.T) {
+	tests := []struct {
+		name     string
+		detect   bool
+		old      string // content of the destination file deleted by the run
+		wantMove bool
+	}{
+		{name: "moved", detect: true, old: "big content", wantMove: true},
+		{name: "without -detect-moves", old: "big content"},
+		{name: "other content", detect: true, old: "big c0ntent"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			writeFile(t, src, "new/big", "big content")
+			old := writeFile(t, dst, "old/big", tt.old)
+			// the link keeps the inode of old from being reused by the copy
+			link := filepath.Join(dst, "..", filepath.Base(dst)+".link")
+			if err := os.Link(old, link); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(link)
+			cfg := testConfig(src, dst)
+			cfg.DetectMoves = tt.detect
+			_, f := runTest(t, cfg)
+			moved := filepath.Join(dst, "new", "big")
+			want := []string{"copy " + moved, "delete-dir " + filepath.Join(dst, "old"), "mkdir " + filepath.Dir(moved)}
+			if tt.wantMove {
+				want = []string{"delete-dir " + filepath.Join(dst, "old"), "mkdir " + filepath.Dir(moved), "move " + moved}
+			}
+			if got := f.sortedActions(); !stringsEqual(got, want) {
+				t.Errorf("actions = %v, want %v", got, want)
+			}
+			if got := treeFiles(t, dst); !stringsEqual(got, []string{"new/big"}) {
+				t.Errorf("destination = %v", got)
+			}
+			before, err := os.Stat(link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, err := os.Stat(moved)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if renamed := os.SameFile(before, after); renamed != tt.wantMove {
+				t.Errorf("renamed = %v, want %v", renamed, tt.wantMove)
+			}
+		})
+	}
+}
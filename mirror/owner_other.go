@@ -0,0 +1,10 @@
+//go:build !unix
+
+package mirror
+
+import "io/fs"
+
+// fileOwner is not supported on platforms without uid/gid
+func fileOwner(inf fs.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
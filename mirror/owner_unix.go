@@ -0,0 +1,16 @@
+//go:build unix
+
+package mirror
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func fileOwner(inf fs.FileInfo) (uid, gid int, ok bool) {
+	st, ok := inf.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
@@ -0,0 +1,88 @@
+//go:build unix
+
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// chownFS is the OS recording the owners set, or failing to set them with err
+type chownFS struct {
+	FS
+	m      sync.Mutex
+	chowns []string
+	err    error
+}
+
+func (c *chownFS) Lchown(name string, uid, gid int) error {
+	c.m.Lock()
+	c.chowns = append(c.chowns, fmt.Sprintf("%s %d:%d", filepath.Base(name), uid, gid))
+	c.m.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return c.FS.Lchown(name, uid, gid)
+}
+
+func TestOwner(t *testing.T) {
+	uid, gid := os.Getuid(), os.Getgid()
+	owned := func(names ...string) []string {
+		for i, n := range names {
+			names[i] = fmt.Sprintf("%s %d:%d", n, uid, gid)
+		}
+		return names
+	}
+	tests := []struct {
+		name        string
+		owner       bool
+		err         error
+		want        []string
+		wantWarning bool
+	}{
+		{name: "default"},
+		{name: "owner", owner: true, want: owned("f", "l", "sub")},
+		{name: "not permitted", owner: true, err: syscall.EPERM, want: owned("f", "l", "sub"), wantWarning: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			writeFile(t, src, "sub/f", "f")
+			if err := os.Symlink("f", filepath.Join(src, "sub", "l")); err != nil {
+				t.Fatal(err)
+			}
+			cfg := testConfig(src, dst)
+			cfg.Owner = tt.owner
+			fsys := &chownFS{FS: OS, err: tt.err}
+			f := &testFrontend{}
+			if _, err := RunFS(context.Background(), cfg, 1, f, fsys); err != nil {
+				t.Fatalf("RunFS() error = %v, fatal %v", err, f.fatal)
+			}
+			sort.Strings(fsys.chowns)
+			if !stringsEqual(fsys.chowns, tt.want) {
+				t.Errorf("owners set %v, want %v", fsys.chowns, tt.want)
+			}
+			warned := false
+			for _, p := range f.progress {
+				warned = warned || strings.HasPrefix(p, "Warning: cannot set owner")
+			}
+			if warned != tt.wantWarning {
+				t.Errorf("warned %v, want %v", warned, tt.wantWarning)
+			}
+			inf, err := os.Stat(filepath.Join(dst, "sub", "f"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st := inf.Sys().(*syscall.Stat_t); int(st.Uid) != uid || int(st.Gid) != gid {
+				t.Errorf("owner %d:%d, want %d:%d", st.Uid, st.Gid, uid, gid)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package mirror
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/binChris/mirror/config"
+)
+
+// ErrPlanDrift is returned by Run when applying a plan if actions were skipped
+// because the filesystem no longer matched the plan
+var ErrPlanDrift = errors.New("filesystem changed since the plan was made")
+
+// plan is the list of actions a run would execute. It is written instead of executing them,
+// can be edited and then applied by a later run.
+type plan struct {
+	m           sync.Mutex
+	Source      string       `json:"source"`
+	Destination string       `json:"destination"`
+	Actions     []planAction `json:"actions"`
+}
+
+type planAction struct {
+	Action string     `json:"action"`           // mkdir, copy, symlink, delete-file or delete-dir
+	Path   string     `json:"path"`             // relative to the destination dir
+	Source string     `json:"source,omitempty"` // relative to the source dir, copy and symlink only
+	Src    *fileState `json:"src,omitempty"`    // state of the source file or symlink to copy
+	Dst    *fileState `json:"dst,omitempty"`    // state of the destination file, nil if it does not exist
+	Tree   string     `json:"tree,omitempty"`   // hash of the entries below the dir, delete-dir only
+}
+
+type fileState struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Target  string    `json:"target,omitempty"` // of a symlink
+}
+
+var actionOrder = map[string]int{"mkdir": 0, "copy": 1, "symlink": 1, "delete-file": 2, "delete-dir": 3}
+
+// stateOf returns the state of the file at path, of a symlink itself unless follow
+func stateOf(path string, follow bool) (*fileState, error) {
+	stat := os.Lstat
+	if follow {
+		stat = os.Stat
+	}
+	inf, err := stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &fileState{Size: inf.Size(), ModTime: inf.ModTime()}
+	if inf.Mode()&fs.ModeSymlink != 0 {
+		if s.Target, err = os.Readlink(path); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *fileState) equal(o *fileState) bool {
+	if s == nil || o == nil {
+		return s == o
+	}
+	return s.Size == o.Size && s.ModTime.Equal(o.ModTime) && s.Target == o.Target
+}
+
+// treeState returns a hash of the names, types, sizes and mtimes of the entries below the dir at path
+func treeState(path string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(path, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		inf, err := e.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s %s %d %d\n", relPath(path, p, ""), inf.Mode().Type(), inf.Size(), inf.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// record adds an action for the destination path dst and, for copies and symlinks, the source path src
+func (m *mirror) record(action, src, dst string) {
+	a := planAction{
+		Action: action,
+		Path:   relPath(m.dstRoot, dst, ""),
+	}
+	var err error
+	if action == "copy" || action == "symlink" {
+		a.Source = relPath(m.srcRoot, src, "")
+		if a.Src, err = stateOf(src, action == "copy"); err != nil {
+			m.fail(fmt.Sprintf("Cannot get file info for '%s': %s", src, err))
+			return
+		}
+	}
+	if action != "mkdir" && action != "delete-dir" {
+		if a.Dst, err = stateOf(dst, false); err != nil {
+			m.fail(fmt.Sprintf("Cannot get file info for '%s': %s", dst, err))
+			return
+		}
+	}
+	if action == "delete-dir" {
+		if a.Tree, err = treeState(dst); err != nil {
+			m.fail(fmt.Sprintf("Cannot read directory '%s': %s", dst, err))
+			return
+		}
+	}
+	m.plan.m.Lock()
+	defer m.plan.m.Unlock()
+	m.plan.Actions = append(m.plan.Actions, a)
+}
+
+func (p *plan) write(path string) error {
+	sort.SliceStable(p.Actions, func(i, j int) bool {
+		a, b := p.Actions[i], p.Actions[j]
+		if actionOrder[a.Action] != actionOrder[b.Action] {
+			return actionOrder[a.Action] < actionOrder[b.Action]
+		}
+		return a.Path < b.Path
+	})
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, b, 0666); err != nil {
+		return fmt.Errorf("write plan '%s': %w", path, err)
+	}
+	fmt.Printf("Plan with %d actions written to %s\n", len(p.Actions), path)
+	return nil
+}
+
+// applyPlan executes the actions of the plan file without scanning. Actions whose files changed
+// since the plan was made are skipped and reported.
+func applyPlan(ctx context.Context, cfg config.Config, frontend Frontend) (Stats, error) {
+	b, err := os.ReadFile(cfg.ApplyPlan)
+	if err != nil {
+		return Stats{}, fmt.Errorf("read plan '%s': %w", cfg.ApplyPlan, err)
+	}
+	var p plan
+	if err := json.Unmarshal(b, &p); err != nil {
+		return Stats{}, fmt.Errorf("parse plan '%s': %w", cfg.ApplyPlan, err)
+	}
+	if !samePath(p.Source, cfg.Source) || !samePath(p.Destination, cfg.Destination) {
+		return Stats{}, fmt.Errorf("plan '%s' was made for %s to %s", cfg.ApplyPlan, p.Source, p.Destination)
+	}
+	for _, a := range p.Actions {
+		// a plan must not reach outside the dirs, or delete the destination itself
+		if !localPath(a.Path) || (a.Action == "copy" || a.Action == "symlink") && !localPath(a.Source) {
+			return Stats{}, fmt.Errorf("plan '%s' has a %s action for a path outside the dirs: '%s'", cfg.ApplyPlan, a.Action, a.Path)
+		}
+	}
+	m := mirror{
+		fs:       OS,
+		frontend: frontend,
+		copyOpts: copyOptionsFrom(cfg),
+		dstRoot:  cfg.Destination,
+	}
+	if cfg.Trash != "" {
+		if m.trash, err = trashRunDir(cfg.Trash, cfg.Destination); err != nil {
+			return Stats{}, err
+		}
+	}
+	var stats Stats
+	start := time.Now()
+	applied, drifted := 0, 0
+	for _, a := range p.Actions {
+		if err := ctx.Err(); err != nil {
+			fmt.Printf("Interrupted after %d actions\n", applied)
+			return stats, err
+		}
+		dst := filepath.Join(cfg.Destination, filepath.FromSlash(a.Path))
+		src := filepath.Join(cfg.Source, filepath.FromSlash(a.Source))
+		if reason := a.drift(src, dst); reason != "" {
+			fmt.Printf("Skipping %s %s: %s\n", a.Action, a.Path, reason)
+			drifted++
+			continue
+		}
+		m.frontend.Progress(fmt.Sprintf("%s %s", a.Action, dst))
+		switch a.Action {
+		case "mkdir":
+			err = os.Mkdir(dst, 0777)
+			stats.DirsCreated++
+		case "copy":
+			err = copyFile(m.fs, src, dst, m.copyOpts)
+			stats.FilesCopied++
+			if a.Src != nil {
+				stats.BytesCopied += uint64(a.Src.Size)
+			}
+		case "symlink":
+			err = copySymlink(m.fs, src, dst)
+			stats.FilesCopied++
+		case "delete-file":
+			err = m.remove(dst, false)
+			stats.FilesDeleted++
+		case "delete-dir":
+			err = m.remove(dst, true)
+			stats.DirsDeleted++
+		default:
+			err = fmt.Errorf("unknown action '%s'", a.Action)
+		}
+		if err != nil {
+			m.fail(fmt.Sprintf("Cannot %s '%s': %s", a.Action, dst, err))
+			return stats, m.err
+		}
+		applied++
+	}
+	stats.Duration = time.Since(start)
+	fmt.Printf("%d actions applied, %d skipped because the filesystem changed\n", applied, drifted)
+	if stats.BytesCopied > 0 {
+		fmt.Println(stats.throughput())
+	}
+	if drifted > 0 {
+		return stats, ErrPlanDrift
+	}
+	return stats, nil
+}
+
+// drift returns why the action no longer matches the filesystem, or "" if it can be applied
+func (a planAction) drift(src, dst string) string {
+	switch a.Action {
+	case "mkdir":
+		if _, err := os.Stat(dst); err == nil {
+			return "already exists"
+		}
+	case "delete-dir":
+		if inf, err := os.Stat(dst); err != nil || !inf.IsDir() {
+			return "no longer a dir"
+		}
+		if tree, err := treeState(dst); a.Tree != "" && (err != nil || tree != a.Tree) {
+			return "contents changed"
+		}
+	case "copy", "symlink", "delete-file":
+		if a.Action != "delete-file" {
+			if s, err := stateOf(src, a.Action == "copy"); err != nil || !s.equal(a.Src) {
+				return "source changed"
+			}
+		}
+		if d, err := stateOf(dst, false); err != nil || !d.equal(a.Dst) {
+			return "destination changed"
+		}
+	}
+	return ""
+}
+
+// localPath reports whether the slash separated path p names an entry below a dir
+func localPath(p string) bool {
+	p = filepath.FromSlash(p)
+	return filepath.IsLocal(p) && filepath.Clean(p) != "."
+}
+
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
@@ -0,0 +1,211 @@
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/binChris/mirror/config"
+)
+
+func TestApplyPlanRejectsPathsOutsideTheDirs(t *testing.T) {
+	tests := []struct {
+		name   string
+		action planAction
+	}{
+		{name: "parent", action: planAction{Action: "delete-dir", Path: ".."}},
+		{name: "empty", action: planAction{Action: "delete-dir", Path: ""}},
+		{name: "destination", action: planAction{Action: "delete-dir", Path: "."}},
+		{name: "escaping", action: planAction{Action: "delete-file", Path: "sub/../../x"}},
+		{name: "absolute", action: planAction{Action: "delete-file", Path: "/etc/passwd"}},
+		{name: "copy source", action: planAction{Action: "copy", Path: "a", Source: "../a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			writeFile(t, dst, "keep", "keep")
+			cfg := testConfig(src, dst)
+			cfg.ApplyPlan = writePlan(t, &plan{Source: src, Destination: dst, Actions: []planAction{tt.action}})
+			if _, err := Run(context.Background(), cfg, 1, &testFrontend{}); err == nil {
+				t.Fatal("Run() applied the plan")
+			}
+			if got := readFile(t, filepath.Join(dst, "keep")); got != "keep" {
+				t.Errorf("destination changed")
+			}
+		})
+	}
+}
+
+func TestApplyPlanDrift(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, dst string)
+		want   error
+	}{
+		{name: "unchanged"},
+		{
+			name:   "file added to deleted dir",
+			change: func(t *testing.T, dst string) { writeFile(t, dst, "old/new", "new") },
+			want:   ErrPlanDrift,
+		},
+		{
+			name:   "file in deleted dir changed",
+			change: func(t *testing.T, dst string) { writeFile(t, dst, "old/a", "changed") },
+			want:   ErrPlanDrift,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			writeFile(t, dst, "old/a", "a")
+			cfg := testConfig(src, dst)
+			cfg.PlanOut = filepath.Join(t.TempDir(), "plan.json")
+			runTest(t, cfg)
+			if tt.change != nil {
+				tt.change(t, dst)
+			}
+			cfg.PlanOut, cfg.ApplyPlan = "", cfg.PlanOut
+			_, err := Run(context.Background(), cfg, 1, &testFrontend{})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Run() error = %v, want %v", err, tt.want)
+			}
+			_, statErr := os.Stat(filepath.Join(dst, "old"))
+			if deleted := os.IsNotExist(statErr); deleted != (tt.want == nil) {
+				t.Errorf("dir deleted = %v", deleted)
+			}
+		})
+	}
+}
+
+func TestApplyPlanSymlink(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeFile(t, src, "a", "a")
+	if err := os.Symlink("a", filepath.Join(src, "l")); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig(src, dst)
+	cfg.PlanOut = filepath.Join(t.TempDir(), "plan.json")
+	runTest(t, cfg)
+	cfg.PlanOut, cfg.ApplyPlan = "", cfg.PlanOut
+	runTest(t, cfg)
+	if got := readFile(t, filepath.Join(dst, "a")); got != "a" {
+		t.Errorf("content of a = %q", got)
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "l")); err != nil || target != "a" {
+		t.Errorf("link target = %q, %v, want %q", target, err, "a")
+	}
+}
+
+func TestSubtreeCacheSaved(t *testing.T) {
+	tests := []struct {
+		name   string
+		plan   bool
+		answer rune
+		want   bool
+	}{
+		{name: "forced", want: true},
+		{name: "plan", plan: true},
+		{name: "declined", answer: 'n'},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			writeFile(t, src, "sub/a", "a")
+			writeFile(t, dst, "sub/orphan", "orphan")
+			cfg := testConfig(src, dst)
+			cfg.SubtreeCache = filepath.Join(t.TempDir(), "cache.json")
+			if tt.plan {
+				cfg.PlanOut = filepath.Join(t.TempDir(), "plan.json")
+			}
+			if tt.answer != 0 {
+				ask := '-'
+				cfg.DeleteFile = &ask
+			}
+			if _, err := Run(context.Background(), cfg, 1, &testFrontend{answer: tt.answer}); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			_, err := os.Stat(cfg.SubtreeCache)
+			if saved := err == nil; saved != tt.want {
+				t.Errorf("cache saved = %v, want %v", saved, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubtreeCacheInvalidated(t *testing.T) {
+	tests := []struct {
+		name       string
+		first      func(cfg *config.Config)
+		change     func(t *testing.T, cfg *config.Config)
+		wantCopied uint64
+	}{
+		{name: "unchanged"},
+		{
+			name:       "source file changed",
+			change:     func(t *testing.T, cfg *config.Config) { writeFile(t, cfg.Source, "sub/a", "changed") },
+			wantCopied: 1,
+		},
+		{
+			name: "destination file removed",
+			change: func(t *testing.T, cfg *config.Config) {
+				if err := os.Remove(filepath.Join(cfg.Destination, "sub/a")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantCopied: 1,
+		},
+		{
+			name:       "other destination",
+			change:     func(t *testing.T, cfg *config.Config) { cfg.Destination = t.TempDir() },
+			wantCopied: 2,
+		},
+		{
+			name:       "other filters",
+			first:      func(cfg *config.Config) { cfg.Filters = []config.FilterRule{{Pattern: "b"}} },
+			wantCopied: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			writeFile(t, src, "sub/a", "a")
+			writeFile(t, src, "sub/b", "b")
+			cfg := testConfig(src, dst)
+			cfg.SubtreeCache = filepath.Join(t.TempDir(), "cache.json")
+			first := cfg
+			if tt.first != nil {
+				tt.first(&first)
+			}
+			runTest(t, first)
+			if tt.change != nil {
+				tt.change(t, &cfg)
+			}
+			stats, _ := runTest(t, cfg)
+			if stats.FilesCopied != tt.wantCopied {
+				t.Errorf("files copied = %d, want %d", stats.FilesCopied, tt.wantCopied)
+			}
+			for _, name := range []string{"sub/a", "sub/b"} {
+				if got, want := readFile(t, filepath.Join(cfg.Destination, name)), readFile(t, filepath.Join(src, name)); got != want {
+					t.Errorf("content of %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+// writePlan writes p to a temporary plan file and returns its path
+func writePlan(t *testing.T, p *plan) string {
+	t.Helper()
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := os.WriteFile(path, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package mirror
+
+import "os"
+
+// preallocate is only supported on Linux, elsewhere the file is just extended
+func preallocate(f *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,109 @@
+package mirror
+
+import (
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/binChris/mirror/config"
+)
+
+// countSource walks the source like the mirror run does and returns the number of files and their bytes.
+// The file infos are cached for the run. Dirs which cannot be read are left to the run to report.
+func (m *mirror) countSource(cfg config.Config) (files int, bytes int64) {
+	ignore := newIgnoreRules(m.fs, cfg.IgnoreFile, m.filters)
+	walkDir(m.fs, cfg.Source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel := relPath(m.srcRoot, path, "")
+		if d.IsDir() && m.maxDepth >= 0 && dirDepth(rel) > m.maxDepth {
+			return filepath.SkipDir
+		}
+		if rel != "." && ignore.excluded(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if ignore.enter(path, rel) != nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		var size int64
+		if d.Type().IsRegular() {
+			inf, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			m.srcStats.put(path, inf)
+			size = inf.Size()
+			if size < m.minSize || m.maxSize > 0 && size > m.maxSize {
+				return nil
+			}
+		}
+		files++
+		bytes += size
+		return nil
+	})
+	return files, bytes
+}
+
+// completed counts the source file at path towards the progress percentage
+func (m *mirror) completed(path string) {
+	if !m.progress {
+		return
+	}
+	var size int64
+	if inf, err := m.srcStats.stat(path); err == nil && inf.Mode().IsRegular() {
+		size = inf.Size()
+	}
+	m.frontend.Completed(int(atomic.AddInt64(&m.filesDone, 1)), atomic.AddInt64(&m.bytesDone, size))
+}
+
+// CopyProgress is implemented by frontends showing the progress of single copies. Their copies are read
+// through user space to be counted, so they don't use clones or in-kernel copies.
+type CopyProgress interface {
+	// CopyProgress reports done of total bytes copied to the destination path
+	CopyProgress(path string, done, total int64)
+}
+
+// copyProgressOf returns the CopyProgress of f or of a frontend wrapped by it, or nil
+func copyProgressOf(f Frontend) CopyProgress {
+	for {
+		if p, ok := f.(CopyProgress); ok {
+			return p
+		}
+		w, ok := f.(interface{ Unwrap() Frontend })
+		if !ok {
+			return nil
+		}
+		f = w.Unwrap()
+	}
+}
+
+// copyProgressInterval is the minimum time between two progress reports of a copy
+const copyProgressInterval = 100 * time.Millisecond
+
+// progressReader reports the bytes read from r, at most every copyProgressInterval and when done
+type progressReader struct {
+	r           io.Reader
+	path        string
+	done, total int64
+	next        time.Time
+	report      func(path string, done, total int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.done += int64(n)
+	if now := time.Now(); err == io.EOF || now.After(r.next) {
+		r.next = now.Add(copyProgressInterval)
+		r.report(r.path, r.done, r.total)
+	}
+	return n, err
+}
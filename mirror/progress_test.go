@@ -0,0 +1,62 @@
+package mirror
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// progressFrontend is a testFrontend recording the progress reports of the copies
+type progressFrontend struct {
+	*testFrontend
+	m       sync.Mutex
+	reports map[string][][2]int64 // done, total by path
+}
+
+func (p *progressFrontend) CopyProgress(path string, done, total int64) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.reports[path] = append(p.reports[path], [2]int64{done, total})
+}
+
+func TestCopyProgress(t *testing.T) {
+	const size = 400 << 10
+	tests := []struct {
+		name string
+		wrap func(p *progressFrontend) Frontend
+	}{
+		{name: "frontend", wrap: func(p *progressFrontend) Frontend { return p }},
+		{name: "wrapped frontend", wrap: func(p *progressFrontend) Frontend { return WithLog(p, io.Discard) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			writeFile(t, src, "big", strings.Repeat("x", size))
+			cfg := testConfig(src, dst)
+			// slowed down to report several times
+			cfg.RateLimit, cfg.BufferSize = 1<<20, 16<<10
+			p := &progressFrontend{testFrontend: &testFrontend{}, reports: make(map[string][][2]int64)}
+			if _, err := Run(context.Background(), cfg, 1, tt.wrap(p)); err != nil {
+				t.Fatalf("Run() error = %v, fatal %v", err, p.fatal)
+			}
+			reports := p.reports[filepath.Join(dst, "big")]
+			if len(reports) < 2 {
+				t.Fatalf("reports %v, want several", reports)
+			}
+			for i, r := range reports {
+				if r[1] != size {
+					t.Errorf("report %d total = %d, want %d", i, r[1], size)
+				}
+				if i > 0 && r[0] < reports[i-1][0] {
+					t.Errorf("report %d done = %d, down from %d", i, r[0], reports[i-1][0])
+				}
+			}
+			if last := reports[len(reports)-1]; last[0] != size {
+				t.Errorf("last report done = %d, want %d", last[0], size)
+			}
+		})
+	}
+}
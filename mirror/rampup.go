@@ -0,0 +1,27 @@
+package mirror
+
+import "time"
+
+// rampUp occupies all but one throttle slot and frees them evenly over d,
+// so the number of concurrent operations grows from 1 to the configured parallelism
+func (m *mirror) rampUp(d time.Duration) {
+	n := cap(m.throttle) - 1
+	if d <= 0 || n == 0 {
+		return
+	}
+	for i := 0; i < n; i++ {
+		m.throttle <- struct{}{}
+	}
+	step := d / time.Duration(n)
+	if step <= 0 {
+		step = 1
+	}
+	go func() {
+		t := time.NewTicker(step)
+		defer t.Stop()
+		for i := 0; i < n; i++ {
+			<-t.C
+			<-m.throttle
+		}
+	}()
+}
@@ -0,0 +1,32 @@
+package mirror
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRampUp(t *testing.T) {
+	tests := []struct {
+		name     string
+		d        time.Duration
+		parallel int
+	}{
+		{name: "off", parallel: 4},
+		{name: "single", d: time.Millisecond, parallel: 1},
+		{name: "shorter than the slots", d: 2, parallel: 4},
+		{name: "even", d: 30 * time.Millisecond, parallel: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mirror{throttle: make(chan struct{}, tt.parallel)}
+			m.rampUp(tt.d)
+			deadline := time.Now().Add(time.Second + tt.d)
+			for len(m.throttle) > 0 {
+				if time.Now().After(deadline) {
+					t.Fatalf("%d slots still occupied", len(m.throttle))
+				}
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
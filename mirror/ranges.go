@@ -0,0 +1,88 @@
+package mirror
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// copyRanges copies src of the given size to dst as n ranges in parallel, each with its own file handles.
+// dst is preallocated to its final size first, unless sparse, which writes holes like copySparse instead.
+func copyRanges(src, dst string, size int64, n int, inplace, sparse bool, minHole int64) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if inplace {
+		flags &^= os.O_TRUNC
+	}
+	dstF, err := os.OpenFile(dst, flags, 0666)
+	if err != nil {
+		return fmt.Errorf("Could not create '%s' for writing: %w", dst, err)
+	}
+	if !sparse {
+		// preallocating would fill the holes
+		err = preallocate(dstF, size)
+	}
+	if err == nil {
+		err = dstF.Truncate(size)
+	}
+	dstF.Close()
+	if err != nil {
+		return fmt.Errorf("preallocate '%s': %w", dst, err)
+	}
+	// ranges are aligned to 1M so no two of them share a file system block
+	const align = 1 << 20
+	chunk := (size/int64(n) + align - 1) / align * align
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for off := int64(0); off < size; off += chunk {
+		wg.Add(1)
+		go func(off int64) {
+			defer wg.Done()
+			if err := copyRange(src, dst, off, min64(chunk, size-off), sparse, minHole); err != nil {
+				errOnce.Do(func() { firstErr = err })
+			}
+		}(off)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// copyRange copies n bytes at offset off from src to dst, leaving zero runs as holes if sparse
+func copyRange(src, dst string, off, n int64, sparse bool, minHole int64) error {
+	srcF, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("Could not open '%s' for reading: %w", src, err)
+	}
+	defer srcF.Close()
+	dstF, err := os.OpenFile(dst, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("Could not open '%s' for writing: %w", dst, err)
+	}
+	defer dstF.Close()
+	if _, err := srcF.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := dstF.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	if sparse {
+		if _, err := writeSparse(dstF, io.LimitReader(srcF, n), minHole); err != nil {
+			return fmt.Errorf("error copying file '%s': %w", src, err)
+		}
+		return nil
+	}
+	if _, err := io.CopyN(dstF, srcF, n); err != nil {
+		return fmt.Errorf("error copying file '%s': %w", src, err)
+	}
+	return nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,79 @@
+package mirror
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyRanges(t *testing.T) {
+	const mb = 1 << 20
+	tests := []struct {
+		name   string
+		size   int
+		n      int
+		sparse bool
+		data   func(b []byte) // fills the source, zero if nil
+	}{
+		{name: "one range", size: mb, n: 1, data: fill},
+		{name: "ranges", size: 3*mb + 5, n: 4, data: fill},
+		{name: "more ranges than MB", size: mb + 1, n: 8, data: fill},
+		{name: "sparse", size: 4 * mb, n: 4, sparse: true, data: func(b []byte) { copy(b, "head"); copy(b[len(b)-4:], "tail") }},
+		{name: "sparse all zero", size: 2 * mb, n: 2, sparse: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			data := make([]byte, tt.size)
+			if tt.data != nil {
+				tt.data(data)
+			}
+			src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+			if err := os.WriteFile(src, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := copyRanges(src, dst, int64(tt.size), tt.n, false, tt.sparse, 4<<10); err != nil {
+				t.Fatalf("copyRanges() error = %v", err)
+			}
+			got, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("copy differs from the source")
+			}
+			if alloc, ok := allocatedBytes(dst); ok && tt.sparse && alloc >= int64(tt.size) {
+				t.Errorf("%d bytes allocated for a sparse file of %d", alloc, tt.size)
+			}
+		})
+	}
+}
+
+func fill(b []byte) {
+	for i := range b {
+		b[i] = byte(i%251 + 1)
+	}
+}
+
+func BenchmarkCopyRanges(b *testing.B) {
+	const size = 64 << 20
+	dir := b.TempDir()
+	data := make([]byte, size)
+	fill(data)
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		b.Fatal(err)
+	}
+	for _, n := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("%d ranges", n), func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				if err := copyRanges(src, filepath.Join(dir, "dst"), size, n, false, false, 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package mirror
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/binChris/mirror/humanize"
+)
+
+// reportRate prints the throughput every interval until the returned function is called. The reports are
+// written like the summary lines, independent of the throttled progress of the frontend.
+func (m *mirror) reportRate(every time.Duration) (stop func()) {
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(every)
+		defer t.Stop()
+		var lastBytes, lastFiles uint64
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+			bytes, files := atomic.LoadUint64(&m.stats.BytesCopied), atomic.LoadUint64(&m.stats.FilesCopied)
+			fmt.Printf("last %s: %s/s, %d files; cumulative: %s\n",
+				every, humanize.Bytes(float64(bytes-lastBytes)/every.Seconds()), files-lastFiles, humanize.Bytes(float64(bytes)))
+			lastBytes, lastFiles = bytes, files
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
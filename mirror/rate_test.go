@@ -0,0 +1,41 @@
+package mirror
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportRate(t *testing.T) {
+	tests := []struct {
+		name  string
+		every time.Duration
+		run   time.Duration
+		min   int
+	}{
+		{name: "none before the first tick", every: time.Hour, run: 20 * time.Millisecond},
+		{name: "every tick", every: 10 * time.Millisecond, run: 55 * time.Millisecond, min: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &testFrontend{}
+			m := &mirror{frontend: f}
+			m.stats.BytesCopied, m.stats.FilesCopied = 1<<20, 3
+			out := captureStdout(t, func() {
+				stop := m.reportRate(tt.every)
+				time.Sleep(tt.run)
+				stop()
+			})
+			lines := strings.Count(out, "\n")
+			if lines < tt.min || tt.min == 0 && lines > 0 {
+				t.Errorf("%d reports, want at least %d:\n%s", lines, tt.min, out)
+			}
+			if tt.min > 0 && !strings.Contains(out, "cumulative: 1") {
+				t.Errorf("cumulative bytes missing:\n%s", out)
+			}
+			if len(f.progress) > 0 {
+				t.Errorf("reports sent as progress: %v", f.progress)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package mirror
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/binChris/mirror/config"
+)
+
+// relativeDestination returns the dir below cfg.Destination recreating the source path, as in rsync -R.
+// With create set, the dirs of the path are created.
+func relativeDestination(cfg config.Config, create bool) (string, error) {
+	src := filepath.Clean(cfg.Source)
+	rel := strings.TrimLeft(strings.TrimPrefix(src, filepath.VolumeName(src)), `/\`)
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("-relative cannot recreate a source path starting with '..': '%s'", cfg.Source)
+	}
+	dst := filepath.Join(cfg.Destination, rel)
+	if create {
+		if err := os.MkdirAll(dst, 0777); err != nil {
+			return "", fmt.Errorf("create dir '%s': %w", dst, err)
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,45 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrReserve is returned by Run if copies were stopped to keep the free space reserve on the destination
+var ErrReserve = errors.New("destination free space reserve reached")
+
+// reserveSpace reports whether a file of size bytes can be copied into dir without the free space
+// dropping below the reserve. If so, the bytes are accounted as pending until releaseSpace is called.
+func (m *mirror) reserveSpace(dir string, size int64) bool {
+	if m.reserveBytes == 0 && m.reservePercent == 0 {
+		return true
+	}
+	if m.reserveHit.Load() {
+		return false
+	}
+	free, total, err := diskSpace(dir)
+	if err != nil {
+		m.fail(fmt.Sprintf("Cannot get free space of '%s': %s", dir, err))
+		return false
+	}
+	reserve := uint64(m.reserveBytes)
+	if r := uint64(m.reservePercent / 100 * float64(total)); r > reserve {
+		reserve = r
+	}
+	pending := uint64(atomic.AddInt64(&m.pendingBytes, size))
+	if free < reserve+pending {
+		atomic.AddInt64(&m.pendingBytes, -size)
+		if !m.reserveHit.Swap(true) {
+			m.frontend.Progress(fmt.Sprintf("Free space reserve of %d bytes reached on %s, no more copies", reserve, dir))
+		}
+		return false
+	}
+	return true
+}
+
+func (m *mirror) releaseSpace(size int64) {
+	if m.reserveBytes != 0 || m.reservePercent != 0 {
+		atomic.AddInt64(&m.pendingBytes, -size)
+	}
+}
@@ -0,0 +1,39 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// transient reports whether err is likely to go away when retried, e.g. a timeout on a network mount.
+// Errors like a full disk or a denied permission are permanent.
+func transient(err error) bool {
+	for _, t := range []error{syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT, syscall.ECONNRESET, syscall.ECONNABORTED, os.ErrDeadlineExceeded} {
+		if errors.Is(err, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyRetrying copies src to dst like copyFile. Transient errors are retried -retries times,
+// waiting -retry-delay before the first retry and twice as long before each further one
+func (m *mirror) copyRetrying(src, dst string) error {
+	delay := m.retryDelay
+	for i := 0; ; i++ {
+		err := copyFile(m.fs, src, dst, m.copyOpts)
+		if err == nil || i == m.retries || !transient(err) {
+			return err
+		}
+		m.frontend.Progress(fmt.Sprintf("Retry %s in %s: %s", src, delay, err))
+		select {
+		case <-time.After(delay):
+		case <-m.ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
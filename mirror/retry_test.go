@@ -0,0 +1,52 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetries(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name         string
+		retries      int
+		err          error
+		want         error
+		wantAttempts int
+	}{
+		{name: "transient", retries: 2, err: syscall.EAGAIN, wantAttempts: 3},
+		{name: "connection reset", retries: 3, err: syscall.ECONNRESET, wantAttempts: 3},
+		{name: "too few retries", retries: 1, err: syscall.EAGAIN, want: ErrFatal, wantAttempts: 2},
+		{name: "permanent", retries: 3, err: syscall.ENOSPC, want: ErrFatal, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := &flakyFS{memFS: newMemFS(), fails: 2, err: tt.err}
+			fsys.file("/s/f", "content", mtime)
+			if err := fsys.Mkdir("/d", 0755); err != nil {
+				t.Fatal(err)
+			}
+			cfg := testConfig("/s", "/d")
+			cfg.Retries, cfg.RetryDelay = tt.retries, time.Millisecond
+			f := &testFrontend{}
+			if _, err := RunFS(context.Background(), cfg, 1, f, fsys); !errors.Is(err, tt.want) {
+				t.Fatalf("RunFS() error = %v, want %v", err, tt.want)
+			}
+			if fsys.attempts != tt.wantAttempts {
+				t.Errorf("%d attempts, want %d", fsys.attempts, tt.wantAttempts)
+			}
+			// failed attempts leave no temporary files
+			want := map[string]string{"f": "content"}
+			if tt.want != nil {
+				want = map[string]string{}
+			}
+			if got := fsys.tree("/d"); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("destination = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package mirror
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// copySparse copies src to dst, seeking over runs of zeros instead of writing them so dst gets holes.
+// Only aligned zero runs of at least minHole bytes, rounded up to the block size of dst, become holes.
+func copySparse(dst *os.File, src io.Reader, minHole int64) error {
+	size, err := writeSparse(dst, src, minHole)
+	if err != nil {
+		return err
+	}
+	// a trailing hole is only allocated by setting the size
+	return dst.Truncate(size)
+}
+
+// writeSparse writes src to dst from its current offset like copySparse, without setting the size of dst.
+// It returns the number of bytes read.
+func writeSparse(dst *os.File, src io.Reader, minHole int64) (int64, error) {
+	chunk := blockSize(dst)
+	if minHole > chunk {
+		chunk = (minHole + chunk - 1) / chunk * chunk
+	}
+	buf := make([]byte, chunk)
+	var size int64
+	for {
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			if isZero(buf[:n]) {
+				if _, err := dst.Seek(int64(n), io.SeekCurrent); err != nil {
+					return size, err
+				}
+			} else if _, err := dst.Write(buf[:n]); err != nil {
+				return size, err
+			}
+			size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return size, nil
+		}
+		if err != nil {
+			return size, err
+		}
+	}
+}
+
+func isZero(b []byte) bool {
+	var zeros [4096]byte
+	for len(b) > 0 {
+		n := len(b)
+		if n > len(zeros) {
+			n = len(zeros)
+		}
+		if !bytes.Equal(b[:n], zeros[:n]) {
+			return false
+		}
+		b = b[n:]
+	}
+	return true
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package mirror
+
+import "os"
+
+func copyDataRegions(dst, src *os.File) error {
+	return errUnsupported
+}
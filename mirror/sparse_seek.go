@@ -0,0 +1,50 @@
+//go:build linux || darwin
+
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyDataRegions copies only the data regions of src, found with SEEK_DATA/SEEK_HOLE, and leaves holes in dst.
+// The regions are copied by the kernel where possible.
+func copyDataRegions(dst, src *os.File) error {
+	inf, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	size := inf.Size()
+	for off := int64(0); off < size; {
+		data, err := src.Seek(off, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// only a trailing hole is left
+			break
+		}
+		if err != nil {
+			if off == 0 {
+				return fmt.Errorf("%w: %s", errUnsupported, err)
+			}
+			return err
+		}
+		hole, err := src.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			return err
+		}
+		if _, err := src.Seek(data, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := dst.Seek(data, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(dst, src, hole-data); err != nil {
+			return err
+		}
+		off = hole
+	}
+	return dst.Truncate(size)
+}
@@ -0,0 +1,53 @@
+package mirror
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSparseCopy(t *testing.T) {
+	const size = 4 << 20
+	tests := []struct {
+		method string
+	}{
+		{method: "auto"},
+		{method: "read-write"},
+		{method: "sendfile"},
+		{method: "reflink"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			dir := t.TempDir()
+			src := writeFile(t, dir, "src", "head")
+			f, err := os.OpenFile(src, os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = f.WriteAt([]byte("tail"), size-4)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if alloc, ok := allocatedBytes(src); !ok || alloc >= size {
+				t.Skip("no sparse files on this file system")
+			}
+			dst := filepath.Join(dir, "dst")
+			err = copyFile(OS, src, dst, copyOptions{method: tt.method, sparse: true, sparseMinHole: 4 << 10, umask: -1})
+			if err != nil {
+				if tt.method == "reflink" {
+					t.Skipf("reflink: %v", err)
+				}
+				t.Fatalf("copyFile() error = %v", err)
+			}
+			want, _ := os.ReadFile(src)
+			if got, _ := os.ReadFile(dst); !bytes.Equal(got, want) {
+				t.Fatal("copy differs from the source")
+			}
+			if alloc, _ := allocatedBytes(dst); alloc >= size {
+				t.Errorf("%d bytes allocated for a sparse file of %d", alloc, size)
+			}
+		})
+	}
+}
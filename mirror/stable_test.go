@@ -0,0 +1,53 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStable(t *testing.T) {
+	tests := []struct {
+		name   string
+		stable time.Duration
+		want   error
+	}{
+		// the copy notices the change too late
+		{name: "off", want: ErrFatal},
+		{name: "stable", stable: 20 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := &growingFS{memFS: newMemFS(), growing: "/s/growing"}
+			fsys.file("/s/old", "old", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+			// modified recently, so it is checked, but no longer written
+			fsys.file("/s/recent", "recent", time.Now())
+			fsys.file("/s/growing", "growing", time.Now())
+			if err := fsys.Mkdir("/d", 0755); err != nil {
+				t.Fatal(err)
+			}
+			cfg := testConfig("/s", "/d")
+			cfg.Stable = tt.stable
+			f := &testFrontend{}
+			if _, err := RunFS(context.Background(), cfg, 1, f, fsys); !errors.Is(err, tt.want) {
+				t.Fatalf("RunFS() error = %v, want %v", err, tt.want)
+			}
+			if tt.want != nil {
+				if !strings.Contains(fmt.Sprint(f.fatal), "file changed during transfer: '/s/growing'") {
+					t.Errorf("reported %q, want the change of growing", f.fatal)
+				}
+				return
+			}
+			want := []string{"copy /d/old", "copy /d/recent", "skip /d/growing"}
+			if got := f.sortedActions(); !stringsEqual(got, want) {
+				t.Errorf("actions = %v, want %v", got, want)
+			}
+			if _, copied := fsys.tree("/d")["growing"]; copied {
+				t.Error("growing copied")
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package mirror
+
+import (
+	"io/fs"
+	"sync"
+)
+
+// statCache keeps the file info of source paths so passes walking the source repeatedly,
+// e.g. subtree hashing followed by the comparison, stat every entry only once
+type statCache struct {
+	m       sync.Mutex
+	fs      FS
+	entries map[string]fs.FileInfo
+}
+
+func newStatCache(fsys FS) *statCache {
+	return &statCache{fs: fsys, entries: make(map[string]fs.FileInfo)}
+}
+
+// stat returns the cached file info of path, following symlinks like os.Stat
+func (c *statCache) stat(path string) (fs.FileInfo, error) {
+	c.m.Lock()
+	inf, ok := c.entries[path]
+	c.m.Unlock()
+	if ok {
+		return inf, nil
+	}
+	inf, err := c.fs.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	c.put(path, inf)
+	return inf, nil
+}
+
+// put caches inf, which must be the result of following symlinks at path
+func (c *statCache) put(path string, inf fs.FileInfo) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.entries[path] = inf
+}
+
+// invalidate drops path, e.g. after it was detected to have changed
+func (c *statCache) invalidate(path string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	delete(c.entries, path)
+}
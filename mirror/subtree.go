@@ -0,0 +1,168 @@
+package mirror
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/binChris/mirror/config"
+)
+
+// subtreeCache holds aggregate hashes of all source and destination dirs keyed by their path relative
+// to the root. A dir hash covers names, sizes and mtimes of its files and the hashes of its sub dirs, so it
+// changes whenever anything in the subtree changes. The hashes are only valid for the destination and the
+// filters they were saved with, recorded as key.
+type subtreeCache struct {
+	src, dst string
+	cached   subtreeHashes
+	current  subtreeHashes
+	stats    *statCache
+}
+
+type subtreeHashes struct {
+	Key         string            `json:"key"`
+	Source      map[string]string `json:"source"`
+	Destination map[string]string `json:"destination"`
+}
+
+// subtreeKey identifies the destination and the settings deciding which entries are mirrored
+func subtreeKey(cfg config.Config) (string, error) {
+	dst, err := filepath.Abs(cfg.Destination)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(struct {
+		Destination                                    string
+		Filters                                        []config.FilterRule
+		IgnoreFile                                     string
+		MinSize, MaxSize                               int64
+		MaxDepth                                       int
+		FollowSymlinks, SkipDirLinks, Flatten          bool
+		IgnoreCase, DeleteExcluded, NoDelete, Relative bool
+	}{dst, cfg.Filters, cfg.IgnoreFile, cfg.MinSize, cfg.MaxSize, cfg.MaxDepth,
+		cfg.FollowSymlinks, cfg.SkipDirLinks, cfg.Flatten, cfg.IgnoreCase, cfg.DeleteExcluded, cfg.NoDelete, cfg.Relative})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func loadSubtreeCache(path string, cfg config.Config, stats *statCache) (*subtreeCache, error) {
+	key, err := subtreeKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &subtreeCache{
+		src:   cfg.Source,
+		dst:   cfg.Destination,
+		stats: stats,
+	}
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read subtree cache '%s': %w", path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &c.cached); err != nil {
+			return nil, fmt.Errorf("parse subtree cache '%s': %w", path, err)
+		}
+	}
+	if c.cached.Key != key {
+		// saved for another destination or other filters, or by an older version
+		c.cached = subtreeHashes{}
+	}
+	c.current = subtreeHashes{Key: key, Source: make(map[string]string)}
+	if _, err := c.hashDir(c.src, c.src, c.current.Source, stats); err != nil {
+		return nil, err
+	}
+	if c.current.Destination, err = c.hashDestination(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// hashDestination returns the hashes of the destination dirs, none if the destination does not exist
+func (c *subtreeCache) hashDestination() (map[string]string, error) {
+	hashes := make(map[string]string)
+	if _, err := c.stats.fs.Stat(c.dst); errors.Is(err, fs.ErrNotExist) {
+		return hashes, nil
+	}
+	if _, err := c.hashDir(c.dst, c.dst, hashes, nil); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// hashDir adds the hashes of dir and its sub dirs relative to root to hashes, caching the file info
+// of the files in stats if given
+func (c *subtreeCache) hashDir(root, dir string, hashes map[string]string, stats *statCache) (string, error) {
+	ee, err := c.stats.fs.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("read directory '%s': %w", dir, err)
+	}
+	sort.Slice(ee, func(i, j int) bool { return ee[i].Name() < ee[j].Name() })
+	h := sha256.New()
+	for _, e := range ee {
+		if e.IsDir() {
+			sub, err := c.hashDir(root, filepath.Join(dir, e.Name()), hashes, stats)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "d %s %s\n", e.Name(), sub)
+			continue
+		}
+		inf, err := e.Info()
+		if err != nil {
+			return "", fmt.Errorf("get file info for '%s': %w", filepath.Join(dir, e.Name()), err)
+		}
+		if stats != nil && inf.Mode()&fs.ModeSymlink == 0 {
+			stats.put(filepath.Join(dir, e.Name()), inf)
+		}
+		fmt.Fprintf(h, "f %s %d %d\n", e.Name(), inf.Size(), inf.ModTime().UnixNano())
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return "", err
+	}
+	hashes[rel] = sum
+	return sum, nil
+}
+
+// unchanged reports whether the subtree at the source dir and the matching destination dir are both
+// unchanged since the cache was saved
+func (c *subtreeCache) unchanged(dir string) bool {
+	rel, err := filepath.Rel(c.src, dir)
+	if err != nil {
+		return false
+	}
+	src, ok := c.cached.Source[rel]
+	if !ok || src != c.current.Source[rel] {
+		return false
+	}
+	dst, ok := c.cached.Destination[rel]
+	return ok && dst == c.current.Destination[rel]
+}
+
+// save writes the hashes, rehashing the destination as the run changed it
+func (c *subtreeCache) save(path string) error {
+	dst, err := c.hashDestination()
+	if err != nil {
+		return err
+	}
+	c.current.Destination = dst
+	b, err := json.Marshal(c.current)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, b, 0666); err != nil {
+		return fmt.Errorf("write subtree cache '%s': %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package mirror
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// summary is the machine-readable form of the final statistics
+type summary struct {
+	DirsCreated       uint64  `json:"dirs_created"`
+	DirsDeleted       uint64  `json:"dirs_deleted"`
+	FilesCopied       uint64  `json:"files_copied"`
+	FilesDeleted      uint64  `json:"files_deleted"`
+	FilesIdentical    uint64  `json:"files_identical"`
+	BytesCopied       uint64  `json:"bytes_copied"`
+	DurationSeconds   float64 `json:"duration_seconds"`
+	FilesLinked       uint64  `json:"files_linked"`
+	FilesChanged      uint64  `json:"files_changed"`
+	FilesAligned      uint64  `json:"files_aligned"`
+	DirLinksSkipped   uint64  `json:"dir_links_skipped"`
+	CrossMountSkipped uint64  `json:"cross_mount_skipped"`
+	SubtreesSkipped   uint64  `json:"subtrees_skipped"`
+	ScanErrors        int     `json:"scan_errors"`
+	Failures          int     `json:"failures"`
+	StoppedByLimit    bool    `json:"stopped_by_time_limit"`
+}
+
+// writeSummaryJSON writes the final statistics as a single JSON line
+func (m *mirror) writeSummaryJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(summary{
+		DirsCreated:       m.stats.DirsCreated,
+		DirsDeleted:       m.stats.DirsDeleted,
+		FilesCopied:       m.stats.FilesCopied,
+		FilesDeleted:      m.stats.FilesDeleted,
+		FilesIdentical:    m.stats.FilesIdentical,
+		BytesCopied:       m.stats.BytesCopied,
+		DurationSeconds:   m.stats.Duration.Seconds(),
+		FilesLinked:       m.filesLinked,
+		FilesChanged:      m.filesChanged,
+		FilesAligned:      m.filesAligned,
+		DirLinksSkipped:   m.dirLinksSkipped,
+		CrossMountSkipped: m.crossMountSkipped,
+		SubtreesSkipped:   m.subtreesSkipped,
+		ScanErrors:        len(m.scanErrors),
+		Failures:          len(m.failures),
+		StoppedByLimit:    m.stopped.Load(),
+	})
+}
@@ -0,0 +1,33 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// copySymlink recreates the symlink src at dst with the same target, replacing any file at dst
+func copySymlink(fsys FS, src, dst string) error {
+	target, err := fsys.Readlink(src)
+	if err != nil {
+		return fmt.Errorf("read symlink '%s': %w", src, err)
+	}
+	if err := fsys.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove '%s': %w", dst, err)
+	}
+	if err := fsys.Symlink(target, dst); err != nil {
+		return fmt.Errorf("create symlink '%s': %w", dst, err)
+	}
+	return nil
+}
+
+// entriesDiffer reports whether the source file s at sPath needs to be copied over the destination file d at dPath.
+// Unless symlinks are followed, a symlink only matches a symlink with the same target.
+func (m *mirror) entriesDiffer(sPath, dPath string, s, d fs.DirEntry) bool {
+	if m.followLinks || (s.Type()|d.Type())&fs.ModeSymlink == 0 {
+		return m.filesAreDifferent(sPath, dPath)
+	}
+	sTarget, sErr := m.fs.Readlink(sPath)
+	dTarget, dErr := m.fs.Readlink(dPath)
+	return sErr != nil || dErr != nil || sTarget != dTarget
+}
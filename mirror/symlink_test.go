@@ -0,0 +1,64 @@
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymlinks(t *testing.T) {
+	tests := []struct {
+		name   string
+		follow bool
+		link   string // target of the source symlink l
+		// the destination entry l: a symlink target, or the content of a file, or file "b" for a dir
+		wantLink, wantFile string
+		wantDir            bool
+	}{
+		{name: "preserve link to file", link: "a", wantLink: "a"},
+		{name: "preserve link to dir", link: "d", wantLink: "d"},
+		{name: "preserve dangling link", link: "missing", wantLink: "missing"},
+		{name: "follow link to file", follow: true, link: "a", wantFile: "a"},
+		{name: "follow link to dir", follow: true, link: "d", wantDir: true},
+		{name: "follow link to parent", follow: true, link: "."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			writeFile(t, src, "a", "a")
+			writeFile(t, src, "d/b", "b")
+			if err := os.Symlink(tt.link, filepath.Join(src, "l")); err != nil {
+				t.Fatal(err)
+			}
+			cfg := testConfig(src, dst)
+			cfg.FollowSymlinks = tt.follow
+			runTest(t, cfg)
+			l := filepath.Join(dst, "l")
+			inf, err := os.Lstat(l)
+			switch {
+			case tt.wantLink != "":
+				if target, err := os.Readlink(l); err != nil || target != tt.wantLink {
+					t.Errorf("link target = %q, %v, want %q", target, err, tt.wantLink)
+				}
+			case tt.wantFile != "":
+				if err != nil || !inf.Mode().IsRegular() {
+					t.Fatalf("not a file: %v", err)
+				}
+				if got := readFile(t, l); got != tt.wantFile {
+					t.Errorf("content = %q, want %q", got, tt.wantFile)
+				}
+			case tt.wantDir:
+				if err != nil || !inf.IsDir() {
+					t.Fatalf("not a dir: %v", err)
+				}
+				if got := readFile(t, filepath.Join(l, "b")); got != "b" {
+					t.Errorf("content of b = %q", got)
+				}
+			default:
+				if !os.IsNotExist(err) {
+					t.Errorf("l copied: %v", err)
+				}
+			}
+		})
+	}
+}
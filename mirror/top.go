@@ -0,0 +1,53 @@
+package mirror
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+type copiedFile struct {
+	path string
+	size int64
+}
+
+// fileHeap is a min-heap of files by size
+type fileHeap []copiedFile
+
+func (h fileHeap) Len() int           { return len(h) }
+func (h fileHeap) Less(i, j int) bool { return h[i].size < h[j].size }
+func (h fileHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *fileHeap) Push(x any)        { *h = append(*h, x.(copiedFile)) }
+func (h *fileHeap) Pop() any {
+	old := *h
+	f := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return f
+}
+
+// largestFiles keeps the n largest files added to it
+type largestFiles struct {
+	m     sync.Mutex
+	n     int
+	files fileHeap
+}
+
+func (l *largestFiles) add(path string, size int64) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if len(l.files) < l.n {
+		heap.Push(&l.files, copiedFile{path: path, size: size})
+	} else if size > l.files[0].size {
+		l.files[0] = copiedFile{path: path, size: size}
+		heap.Fix(&l.files, 0)
+	}
+}
+
+// sorted returns the files, largest first
+func (l *largestFiles) sorted() []copiedFile {
+	l.m.Lock()
+	defer l.m.Unlock()
+	files := append([]copiedFile(nil), l.files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].size > files[j].size })
+	return files
+}
@@ -0,0 +1,96 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// trashRunDir returns the dir in trash receiving the deletes of a run started now
+func trashRunDir(trash, dstRoot string) (string, error) {
+	abs, err := filepath.Abs(trash)
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.Abs(dstRoot)
+	if err != nil {
+		return "", err
+	}
+	// the trash would be deleted as an orphan
+	if rel, err := filepath.Rel(root, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("trash dir '%s' must not be inside the destination", trash)
+	}
+	return filepath.Join(abs, time.Now().Format("2006-01-02T15-04-05")), nil
+}
+
+// moveToTrash moves the file or dir tree at path to its path relative to the destination in the trash dir.
+// If the trash is on a different filesystem it is copied and then removed.
+func (m *mirror) moveToTrash(path string) error {
+	target, err := m.trashPath(path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+		return err
+	}
+	err = m.fs.Rename(path, target)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyTree(path, target); err != nil {
+		return fmt.Errorf("copy to trash: %w", err)
+	}
+	return os.RemoveAll(path)
+}
+
+// trashEmptyDir removes the empty dir at path, whose entries were moved to the trash one by one,
+// and creates it in the trash unless its entries did so
+func (m *mirror) trashEmptyDir(path string) error {
+	target, err := m.trashPath(path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(target, 0777); err != nil {
+		return err
+	}
+	return m.fs.Remove(path)
+}
+
+// trashPath returns the path in the trash dir of the destination entry at path
+func (m *mirror) trashPath(path string) (string, error) {
+	rel, err := filepath.Rel(m.dstRoot, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(m.trash, rel), nil
+}
+
+// copyTree copies the file, symlink or dir tree at src to dst
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case e.IsDir():
+			inf, err := e.Info()
+			if err != nil {
+				return err
+			}
+			return os.MkdirAll(target, inf.Mode().Perm()|0700)
+		case e.Type()&fs.ModeSymlink != 0:
+			return copySymlink(OS, path, target)
+		}
+		return copyFile(OS, path, target, copyOptions{})
+	})
+}
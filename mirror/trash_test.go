@@ -0,0 +1,47 @@
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrash(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      []string
+		crossMount bool
+	}{
+		{name: "file", files: []string{"a.txt"}},
+		{name: "nested file", files: []string{"sub/b.txt"}},
+		{name: "dir", files: []string{"old/c.txt", "old/deeper/d.txt"}},
+		{name: "with cross-mount guard", files: []string{"a.txt", "old/c.txt"}, crossMount: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst, trash := t.TempDir(), t.TempDir(), t.TempDir()
+			if err := os.Mkdir(filepath.Join(src, "sub"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range tt.files {
+				writeFile(t, dst, f, f)
+			}
+			cfg := testConfig(src, dst)
+			cfg.Trash = trash
+			cfg.NoCrossMountDelete = tt.crossMount
+			runTest(t, cfg)
+			for _, f := range tt.files {
+				if _, err := os.Lstat(filepath.Join(dst, f)); !os.IsNotExist(err) {
+					t.Errorf("%s still in the destination: %v", f, err)
+				}
+				found, _ := filepath.Glob(filepath.Join(trash, "*", f))
+				if len(found) != 1 {
+					t.Fatalf("%s in the trash %d times", f, len(found))
+				}
+				if got := readFile(t, found[0]); got != f {
+					t.Errorf("%s in the trash has content %q", f, got)
+				}
+			}
+		})
+	}
+}
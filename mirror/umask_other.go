@@ -0,0 +1,8 @@
+//go:build !unix
+
+package mirror
+
+// setUmask is a no-op on platforms without umask
+func setUmask(mask int) int {
+	return mask
+}
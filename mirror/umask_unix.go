@@ -0,0 +1,9 @@
+//go:build unix
+
+package mirror
+
+import "syscall"
+
+func setUmask(mask int) int {
+	return syscall.Umask(mask)
+}
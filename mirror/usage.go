@@ -0,0 +1,37 @@
+package mirror
+
+import (
+	"fmt"
+
+	"github.com/binChris/mirror/humanize"
+)
+
+// diskUsage is the space used and available on the file system of a dir
+type diskUsage struct {
+	used, free uint64
+}
+
+func usageOf(dir string) (diskUsage, error) {
+	free, total, err := diskSpace(dir)
+	if err != nil {
+		return diskUsage{}, fmt.Errorf("get disk space of '%s': %w", dir, err)
+	}
+	return diskUsage{used: total - free, free: free}, nil
+}
+
+// printUsage prints how the disk usage of dir changed since before. The change can differ from the
+// bytes copied, e.g. due to hard links, reflinks, deletions or other writers on the file system.
+func (m *mirror) printUsage(dir string, before diskUsage) {
+	after, err := usageOf(dir)
+	if err != nil {
+		m.frontend.Progress(fmt.Sprintf("Warning: %s", err))
+		return
+	}
+	sign, delta := "+", float64(after.used)-float64(before.used)
+	if delta < 0 {
+		sign, delta = "-", -delta
+	}
+	fmt.Printf("Destination disk: %s used before, %s after (%s%s), %s free; %s copied\n",
+		humanize.Bytes(float64(before.used)), humanize.Bytes(float64(after.used)), sign, humanize.Bytes(delta),
+		humanize.Bytes(float64(after.free)), humanize.Bytes(float64(m.stats.BytesCopied)))
+}
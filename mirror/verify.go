@@ -0,0 +1,142 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/binChris/mirror/config"
+)
+
+// ErrMismatch is returned by Run in verify-existing mode if missing files or unrepaired mismatches were found,
+// and in verify-manifest mode on any discrepancy
+var ErrMismatch = errors.New("destination does not match source")
+
+// verifyExisting hashes every source file and its destination counterpart and reports mismatches.
+// With cfg.Repair set, mismatching files are copied again.
+func verifyExisting(ctx context.Context, cfg config.Config, parallel int, frontend Frontend) error {
+	m := mirror{
+		fs:          OS,
+		frontend:    frontend,
+		throttle:    make(chan struct{}, parallel),
+		srcStats:    newStatCache(OS),
+		copyOpts:    copyOptionsFrom(cfg),
+		filters:     cfg.Filters,
+		mmapCompare: cfg.MmapCompare,
+		mmapMin:     cfg.MmapMinSize,
+	}
+	m.rampUp(cfg.RampUp)
+	var (
+		mu         sync.Mutex
+		verified   int
+		missing    []string
+		mismatches []string
+		repaired   int
+	)
+	ignore := newIgnoreRules(OS, cfg.IgnoreFile, m.filters)
+	err := filepath.WalkDir(cfg.Source, func(src string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(cfg.Source, src)
+		if err != nil {
+			return err
+		}
+		if rel != "." && ignore.excluded(filepath.ToSlash(rel), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return ignore.enter(src, filepath.ToSlash(rel))
+		}
+		if !d.Type().IsRegular() || m.failed.Load() {
+			return nil
+		}
+		dst := filepath.Join(cfg.Destination, rel)
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.throttle <- struct{}{}
+			defer func() { <-m.throttle }()
+			m.frontend.Progress(fmt.Sprintf("Verifying %s", dst))
+			same, exists := m.sameContent(src, dst)
+			repair := exists && !same && cfg.Repair && m.allow(cfg.OverwriteFile, "Repair file '%s'", dst)
+			if repair {
+				if err := copyFile(m.fs, src, dst, m.copyOpts); err != nil {
+					m.fail(err.Error())
+					return
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			verified++
+			switch {
+			case !exists:
+				missing = append(missing, dst)
+			case same:
+			case repair:
+				repaired++
+			default:
+				mismatches = append(mismatches, dst)
+			}
+		}()
+		return nil
+	})
+	m.wg.Wait()
+	if m.failed.Load() {
+		return m.err
+	}
+	if err != nil {
+		return fmt.Errorf("walk '%s': %w", cfg.Source, err)
+	}
+	sort.Strings(missing)
+	sort.Strings(mismatches)
+	for _, p := range missing {
+		fmt.Printf("Missing: %s\n", p)
+	}
+	for _, p := range mismatches {
+		fmt.Printf("Mismatch: %s\n", p)
+	}
+	fmt.Printf("%d files verified, %d missing, %d mismatches, %d repaired\n",
+		verified, len(missing), len(mismatches), repaired)
+	if len(missing) > 0 || len(mismatches) > 0 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// sameContent compares the files by size and content. exists is false if dst does not exist
+func (m *mirror) sameContent(src, dst string) (same, exists bool) {
+	dInf, err := os.Stat(dst)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, false
+	}
+	if err != nil {
+		m.fail(fmt.Sprintf("Cannot get file info for '%s': %s", dst, err))
+		return true, true
+	}
+	sInf, err := m.srcStats.stat(src)
+	if err != nil {
+		m.fail(fmt.Sprintf("Cannot get file info for '%s': %s", src, err))
+		return true, true
+	}
+	if sInf.Size() != dInf.Size() {
+		return false, true
+	}
+	same, err = m.equalContent(src, dst, sInf.Size())
+	if err != nil {
+		m.fail(err.Error())
+		return true, true
+	}
+	return same, true
+}
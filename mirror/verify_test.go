@@ -0,0 +1,44 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyExisting(t *testing.T) {
+	tests := []struct {
+		name    string
+		dst     map[string]string
+		repair  bool
+		want    error
+		wantDst string
+	}{
+		{name: "identical", dst: map[string]string{"a": "a", "sub/b": "b"}, wantDst: "a"},
+		{name: "missing", dst: map[string]string{"a": "a"}, want: ErrMismatch, wantDst: "a"},
+		{name: "missing not repaired", dst: map[string]string{"a": "a"}, repair: true, want: ErrMismatch, wantDst: "a"},
+		{name: "mismatch", dst: map[string]string{"a": "x", "sub/b": "b"}, want: ErrMismatch, wantDst: "x"},
+		{name: "mismatch repaired", dst: map[string]string{"a": "x", "sub/b": "b"}, repair: true, wantDst: "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			writeFile(t, src, "a", "a")
+			writeFile(t, src, "sub/b", "b")
+			for p, c := range tt.dst {
+				writeFile(t, dst, p, c)
+			}
+			cfg := testConfig(src, dst)
+			cfg.VerifyExisting = true
+			cfg.Repair = tt.repair
+			_, err := Run(context.Background(), cfg, 2, &testFrontend{})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Run() error = %v, want %v", err, tt.want)
+			}
+			if got := readFile(t, filepath.Join(dst, "a")); got != tt.wantDst {
+				t.Errorf("destination a = %q, want %q", got, tt.wantDst)
+			}
+		})
+	}
+}
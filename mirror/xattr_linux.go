@@ -0,0 +1,48 @@
+package mirror
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+)
+
+func (osFS) Listxattr(name string) ([]string, error) {
+	size, err := syscall.Listxattr(name, nil)
+	if errors.Is(err, syscall.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(name, buf); err != nil {
+		return nil, err
+	}
+	var attrs []string
+	for _, attr := range strings.Split(string(buf[:size]), "\x00") {
+		if attr != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+	return attrs, nil
+}
+
+func (osFS) Getxattr(name, attr string) ([]byte, error) {
+	size, err := syscall.Getxattr(name, attr, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Getxattr(name, attr, buf); err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
+
+func (osFS) Setxattr(name, attr string, value []byte) error {
+	return syscall.Setxattr(name, attr, value, 0)
+}
+
+func (osFS) Removexattr(name, attr string) error {
+	return syscall.Removexattr(name, attr)
+}
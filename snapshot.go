@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/binChris/mirror/config"
+)
+
+// runHook runs a snapshot hook command through the shell. The source dir and snapshot mount
+// are available to it as MIRROR_SOURCE and MIRROR_SNAPSHOT_MOUNT.
+func runHook(command string, cfg config.Config) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(),
+		"MIRROR_SOURCE="+cfg.Source,
+		"MIRROR_SNAPSHOT_MOUNT="+cfg.SnapshotMount,
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("run '%s': %w", command, err)
+	}
+	return nil
+}